@@ -0,0 +1,182 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Access control for /ws and the admin routes
+package main
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Everything is served from one port, so each kind of route has its
+// own list of networks that may use it: -wsAllow for the websocket,
+// -metricsAllow for /metrics, -healthAllow for /health and
+// -adminAllow for /status/registration and any other admin route. An
+// empty list lets everyone in. We go by the address the connection
+// came from, not by X-Forwarded-For, which anyone can set.
+//
+// Browsers send an Origin header with a websocket upgrade, so -wsOrigins
+// lists the origins that may open one, such as https://gameontext.org
+// or https://*.example.org. The mediator is not a browser and sends no
+// Origin, so an upgrade without one is not refused for that reason.
+//
+// Every refusal is logged with the remote address and counted.
+
+// An accessPolicy names the networks that may use a group of routes.
+type accessPolicy struct {
+	name string
+	nets []*net.IPNet
+}
+
+// The policies, filled in by processCommandline.
+var (
+	wsAccess      = accessPolicy{name: "ws"}
+	metricsAccess = accessPolicy{name: "metrics"}
+	healthAccess  = accessPolicy{name: "health"}
+	adminAccess   = accessPolicy{name: "admin"}
+	// The origins that may open a websocket; empty means any.
+	wsOrigins []string
+)
+
+// Parses a comma-separated list of CIDRs and addresses, which stand
+// for themselves alone.
+func parseNets(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, ArgError{fmt.Sprintf("'%s' is not an address or CIDR.", s)}
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, ArgError{fmt.Sprintf("'%s' is not an address or CIDR.", s)}
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Parses a comma-separated list of origins, which may contain *.
+func parseOrigins(list string) ([]string, error) {
+	var origins []string
+	for _, s := range strings.Split(list, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if len(s) == 0 {
+			continue
+		}
+		if _, err := path.Match(s, ""); err != nil {
+			return nil, ArgError{fmt.Sprintf("Bad origin pattern '%s'.", s)}
+		}
+		origins = append(origins, strings.TrimSuffix(s, "/"))
+	}
+	return origins, nil
+}
+
+// Sets up the policies from the -wsAllow, -metricsAllow, -healthAllow,
+// -adminAllow and -wsOrigins flags.
+func loadAccessPolicies() (err error) {
+	for _, p := range []struct {
+		policy *accessPolicy
+		list   string
+	}{
+		{&wsAccess, config.wsAllow},
+		{&metricsAccess, config.metricsAllow},
+		{&healthAccess, config.healthAllow},
+		{&adminAccess, config.adminAllow},
+	} {
+		p.policy.nets, err = parseNets(p.list)
+		if err != nil {
+			return
+		}
+	}
+	wsOrigins, err = parseOrigins(config.wsOrigins)
+	return
+}
+
+// Reports whether a request from remoteAddr, a host and port as in
+// http.Request.RemoteAddr, is allowed.
+func (p *accessPolicy) allows(remoteAddr string) bool {
+	if len(p.nets) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range p.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Logs and counts a refused request.
+func denied(policy, remoteAddr, route, why string) {
+	accessDenied.With(map[string]string{"Policy": policy}).Inc()
+	checkpoint("ACCESS", fmt.Sprintf("DENIED %s to %s: %s", route, remoteAddr, why))
+}
+
+// Returns middleware that refuses requests that p does not allow.
+func allowFrom(p *accessPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !p.allows(c.Request.RemoteAddr) {
+			denied(p.name, c.Request.RemoteAddr, c.Request.URL.Path, "not on the "+p.name+" allowlist")
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Reports whether origin is one of wsOrigins.
+func originAllowed(origin string) bool {
+	if len(wsOrigins) == 0 {
+		return true
+	}
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return false
+	}
+	origin = u.Scheme + "://" + u.Host
+	for _, pattern := range wsOrigins {
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the Origin of a websocket upgrade. It is our upgrader's
+// CheckOrigin.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 || originAllowed(origin) {
+		return true
+	}
+	denied(wsAccess.name, r.RemoteAddr, r.URL.Path, fmt.Sprintf("origin %s is not allowed", origin))
+	return false
+}
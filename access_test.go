@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessPolicies(t *testing.T) {
+	config.metricsAllow = "10.0.0.0/8, 192.168.1.7"
+	config.wsOrigins = "https://gameontext.org, https://*.example.org"
+	defer func() {
+		config.metricsAllow, config.wsOrigins = "", ""
+		loadAccessPolicies()
+	}()
+	if err := loadAccessPolicies(); err != nil {
+		t.Fatalf("loadAccessPolicies failed: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/metrics", allowFrom(&metricsAccess), func(c *gin.Context) { c.String(http.StatusOK, "metrics") })
+	router.GET("/health", allowFrom(&healthAccess), func(c *gin.Context) { c.String(http.StatusOK, "UP") })
+	for _, tc := range []struct {
+		path, from string
+		want       int
+	}{
+		{"/metrics", "10.1.2.3:4000", http.StatusOK},
+		{"/metrics", "192.168.1.7:4000", http.StatusOK},
+		{"/metrics", "192.168.1.8:4000", http.StatusForbidden},
+		{"/metrics", "[::1]:4000", http.StatusForbidden},
+		{"/health", "203.0.113.9:4000", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.RemoteAddr = tc.from
+		// Only the connection's address counts.
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		router.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("GET %s from %s: got %d, want %d", tc.path, tc.from, w.Code, tc.want)
+		}
+	}
+
+	for origin, want := range map[string]bool{
+		"https://gameontext.org":     true,
+		"https://GameOnText.org":     true,
+		"https://rooms.example.org":  true,
+		"http://rooms.example.org":   false,
+		"https://example.org.evil.x": false,
+		"https://evil.example":       false,
+		"null":                       false,
+	} {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Header.Set("Origin", origin)
+		if got := checkOrigin(req); got != want {
+			t.Errorf("checkOrigin(%s) = %v", origin, got)
+		}
+	}
+	if !checkOrigin(httptest.NewRequest("GET", "/ws", nil)) {
+		t.Errorf("An upgrade without an Origin, as the mediator sends, was refused.")
+	}
+
+	config.metricsAllow = "10.0.0.0/33"
+	if err := loadAccessPolicies(); err == nil {
+		t.Errorf("A bad CIDR was accepted.")
+	}
+}
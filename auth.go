@@ -7,47 +7,26 @@
 package main
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"net/http"
+	"sample-room-golang/gameon/mapclient"
 	"time"
 )
 
+// Requests to the map service are signed by the mapclient package.
+// addAuthenticationHeaders signs any other request in the same way.
 func addAuthenticationHeaders(req *http.Request, body string) {
-	// Build the authentication values that Game On! requires. If
-	// the body is empty, do not include it in the calculations.
-	var bodyHash, sig string
-	ts := makeTimestamp()
-	if len(body) > 0 {
-		bodyHash = hash(body)
-		tokens := []string{config.id, ts, bodyHash}
-		sig = buildHmac(tokens, config.secret)
-	} else {
-		bodyHash = hash("")
-		tokens := []string{config.id, ts}
-		sig = buildHmac(tokens, config.secret)
-	}
-	// Set the required headers.
+	// Set the required headers. If the body is empty, it is not
+	// included in the signature.
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json,text/plain")
-	req.Header.Set("gameon-id", config.id)
-	req.Header.Set("gameon-date", ts)
-	req.Header.Set("gameon-sig-body", bodyHash)
-	req.Header.Set("gameon-signature", sig)
-	if config.debug {
-		for _, k := range []string{"gameon-id", "gameon-date", "gameon-sig-body", "gameon-signature"} {
-			log.Printf("%s=%s\n", k, req.Header.Get(k))
-		}
-	}
+	mapclient.SignRequest(req, config.id, sharedSecret(), makeTimestamp(), []byte(body))
 }
 
 func getHandshakeHeader(req *http.Request) http.Header {
 	ts := makeTimestamp()
 	tokens := []string{ts, req.Header.Get("gameon-signature")}
-	newSig := buildHmac(tokens, config.secret)
+	newSig := buildHmac(tokens, handshakeKey())
 
 	return http.Header{
 		"gameon-date":      {ts},
@@ -56,40 +35,32 @@ func getHandshakeHeader(req *http.Request) http.Header {
 
 }
 
-func hash(message string) string {
-	h := sha256.New()
-	h.Write([]byte(message))
-	return base64.StdEncoding.EncodeToString(h.Sum(nil))
-}
-
+// Returns the HMAC of tokens made with secret, which must never be
+// logged.
 func buildHmac(tokens []string, secret string) string {
-	key := []byte(secret)
-	h := hmac.New(sha256.New, key)
-	s := ""
-	for _, t := range tokens {
-		s += t
-	}
-	fmt.Println("Hashing: ", s, "with secret", secret)
-	h.Write([]byte(s))
-	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+	return mapclient.HMAC(secret, tokens...)
 }
 
 // Returns the current time as a UTC-formatted string.
-// If config.timeShift is non-zero, then the timestamp will
-// be shifted by config.timeShift milliseconds. This can be
-// used to slide our registration timestamp closer to the
+// The timestamp is shifted by the clock skew that we have
+// measured (see clock.go) and by config.timeShift milliseconds.
+// This slides our registration timestamp closer to the
 // clock on a remote GameOn! server.
 func makeTimestamp() string {
-	if config.timeShift == 0 {
+	skew := clockSkew.get()
+	if config.timeShift == 0 && skew == 0 {
 		return time.Now().Format(time.RFC1123)
 	}
 	locus := "MAKE.TIMESTAMP"
 	t1 := time.Now()
-	t2 := t1.Add(time.Duration(config.timeShift) * time.Millisecond)
+	t2 := t1.Add(time.Duration(config.timeShift)*time.Millisecond + skew)
 	ourTime := t1.Format(time.RFC1123)
 	serverTime := t2.Format(time.RFC1123)
-	checkpoint(locus, fmt.Sprintf("ourTime    %s", ourTime))
-	checkpoint(locus, fmt.Sprintf("serverTime %s", serverTime))
+	// A measured skew is logged once, when it is measured.
+	if config.timeShift != 0 || config.debug {
+		checkpoint(locus, fmt.Sprintf("ourTime    %s", ourTime))
+		checkpoint(locus, fmt.Sprintf("serverTime %s", serverTime))
+	}
 	return serverTime
 }
 
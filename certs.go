@@ -0,0 +1,137 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Serving TLS
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Given -tlsCert and -tlsKey we serve HTTPS and WSS ourselves rather
+// than relying on a TLS-terminating proxy, and our callback scheme
+// defaults to wss. Every -certPoll seconds we look at the modification
+// times of the two files and, if either has changed, load the pair
+// again, so that a renewed certificate is used without a restart. A
+// pair that fails to load is logged and the old one kept. Connections
+// older than -tlsMinVersion are refused.
+
+// The TLS versions that -tlsMinVersion accepts. Go has only known
+// TLS 1.3 since 1.12, so certs_tls13.go adds it where it can.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+// A certKeeper holds the certificate we serve and notices when its
+// files change.
+type certKeeper struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+	certMod  time.Time
+	keyMod   time.Time
+	cert     *tls.Certificate
+}
+
+var servingCert certKeeper
+
+// Returns the TLS configuration with which to serve, or nil if we
+// serve plain HTTP. Loads the certificate, so fails if it cannot.
+func servingTLS() (*tls.Config, error) {
+	if len(config.tlsCert) == 0 && len(config.tlsKey) == 0 {
+		return nil, nil
+	}
+	if len(config.tlsCert) == 0 || len(config.tlsKey) == 0 {
+		return nil, ArgError{"Give both -tlsCert and -tlsKey, or neither."}
+	}
+	min, ok := tlsVersions[config.tlsMinVersion]
+	if !ok {
+		var known []string
+		for v := range tlsVersions {
+			known = append(known, v)
+		}
+		sort.Strings(known)
+		return nil, ArgError{fmt.Sprintf("Unknown TLS version '%s'; use one of %s.",
+			config.tlsMinVersion, strings.Join(known, ", "))}
+	}
+	if config.certPoll < 0 {
+		return nil, ArgError{"certPoll must not be negative."}
+	}
+	servingCert.certFile, servingCert.keyFile = config.tlsCert, config.tlsKey
+	if _, err := servingCert.reload(); err != nil {
+		return nil, ArgError{fmt.Sprintf("Cannot load our certificate: %s", err.Error())}
+	}
+	return &tls.Config{
+		MinVersion:     min,
+		GetCertificate: servingCert.get,
+	}, nil
+}
+
+// Returns the current certificate. It has the signature of
+// tls.Config.GetCertificate.
+func (k *certKeeper) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.cert, nil
+}
+
+// Loads the certificate and key again if either file has changed since
+// they were last loaded. Returns true if they were. On error we keep
+// the certificate we have.
+func (k *certKeeper) reload() (loaded bool, err error) {
+	certInfo, err := os.Stat(k.certFile)
+	if err != nil {
+		return
+	}
+	keyInfo, err := os.Stat(k.keyFile)
+	if err != nil {
+		return
+	}
+	k.mu.Lock()
+	unchanged := k.cert != nil && certInfo.ModTime().Equal(k.certMod) && keyInfo.ModTime().Equal(k.keyMod)
+	k.mu.Unlock()
+	if unchanged {
+		return
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return
+	}
+	if leaf, perr := x509.ParseCertificate(cert.Certificate[0]); perr == nil {
+		cert.Leaf = leaf
+		certExpiry.Set(float64(leaf.NotAfter.Unix()))
+	}
+	k.mu.Lock()
+	k.cert, k.certMod, k.keyMod = &cert, certInfo.ModTime(), keyInfo.ModTime()
+	k.mu.Unlock()
+	return true, nil
+}
+
+// Watches -tlsCert and -tlsKey for a new certificate until stop is
+// closed.
+func WatchCertificate(stop <-chan struct{}) {
+	locus := "CERT"
+	if config.serveTLS == nil || config.certPoll == 0 {
+		return
+	}
+	for pause(time.Duration(config.certPoll)*time.Second, stop) {
+		loaded, err := servingCert.reload()
+		if err != nil {
+			checkpoint(locus, fmt.Sprintf("RELOAD.FAILED %s, keeping the certificate we have", err.Error()))
+			continue
+		}
+		if loaded {
+			checkpoint(locus, fmt.Sprintf("RELOADED %s", config.tlsCert))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Writes a self-signed certificate for 127.0.0.1 with the given common
+// name, and its key, to certFile and keyFile.
+func writeTestCert(t *testing.T, certFile, keyFile, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600)
+}
+
+func TestServingTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "first")
+	config.tlsCert, config.tlsKey, config.tlsMinVersion = certFile, keyFile, "1.2"
+	defer func() { config.tlsCert, config.tlsKey = "", "" }()
+
+	tc, err := servingTLS()
+	if err != nil {
+		t.Fatalf("servingTLS failed: %v", err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	served := func(max uint16) (string, error) {
+		conn, err := tls.Dial("tcp", ln.Addr().String(),
+			&tls.Config{InsecureSkipVerify: true, MaxVersion: max})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+	if name, err := served(0); err != nil || name != "first" {
+		t.Errorf("Served %q: %v", name, err)
+	}
+	if _, err := served(tls.VersionTLS11); err == nil {
+		t.Errorf("A TLS 1.1 client was accepted although -tlsMinVersion is 1.2.")
+	}
+
+	if loaded, err := servingCert.reload(); loaded || err != nil {
+		t.Errorf("An unchanged certificate was loaded again: %v, %v", loaded, err)
+	}
+	writeTestCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if loaded, err := servingCert.reload(); !loaded || err != nil {
+		t.Fatalf("The renewed certificate was not loaded: %v, %v", loaded, err)
+	}
+	if name, err := served(0); err != nil || name != "second" {
+		t.Errorf("Served %q after the renewal: %v", name, err)
+	}
+
+	ioutil.WriteFile(keyFile, []byte("not a key"), 0600)
+	os.Chtimes(keyFile, later.Add(time.Minute), later.Add(time.Minute))
+	if _, err := servingCert.reload(); err == nil {
+		t.Errorf("A broken key was loaded.")
+	}
+	if name, err := served(0); err != nil || name != "second" {
+		t.Errorf("Served %q after a failed reload: %v", name, err)
+	}
+
+	config.tlsMinVersion = "1.4"
+	if _, err := servingTLS(); err == nil {
+		t.Errorf("An unknown TLS version was accepted.")
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+//go:build go1.12
+// +build go1.12
+
+// TLS 1.3, for Go versions that know it
+package main
+
+import "crypto/tls"
+
+func init() {
+	tlsVersions["1.3"] = tls.VersionTLS13
+}
@@ -0,0 +1,436 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Room management subcommands
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sample-room-golang/gameon/mapclient"
+	"strings"
+	"text/tabwriter"
+)
+
+// Besides serving rooms, the program can manage our registrations
+// without serving anything. A subcommand follows the usual flags, and
+// takes flags and arguments of its own:
+//
+//   room -g gameon.example.org -id ... -secret ... list
+//   room ... show ROOM.1
+//   room ... -c 10.0.0.1 -cp 3000 register -dry-run
+//   room ... -c 10.0.0.1 -cp 3000 update
+//   room ... delete -dry-run 4f6a07399ea23d648568c6d2d000b65
+//   room ... delete -force 4f6a07399ea23d648568c6d2d000b65
+//   room ... -c 10.0.0.1 -cp 3000 prune
+//   room ... -c 10.0.0.1 -cp 3000 doctor
+//
+// Every subcommand takes -json to print JSON rather than text, and
+// those that change registrations take -dry-run to print what they
+// would do without doing it. register and update act on the rooms
+// given by -r or -manifest, and prune keeps the duplicate whose
+// callback is ours, so those three need the callback flags just as
+// serving does. So does doctor (doctor.go), which checks for the usual
+// causes of registration problems.
+//
+// show and delete refuse a site that some other id owns, since the map
+// service looks a site up by id whoever owns it; -force lets them act
+// on it anyway.
+
+// A subcommand is one thing we can do instead of serving rooms.
+type subcommand struct {
+	name string
+	// The arguments that follow the subcommand's flags, for usage.
+	args string
+	help string
+	// The least and most number of arguments.
+	minArgs, maxArgs int
+	// If true the subcommand changes registrations and so takes
+	// -dry-run.
+	mutates bool
+	// If true the subcommand needs our rooms and callback address.
+	needsCallback bool
+	// If true the subcommand takes -force to act on sites that
+	// other ids own.
+	forcible bool
+	run      func(ctx context.Context, mc *mapclient.Client, inv *invocation) error
+}
+
+var subcommands = []*subcommand{
+	{name: "list", help: "List the sites we own.", run: listSites},
+	{name: "show", args: "id|name", help: "Show one of the sites we own.", minArgs: 1, maxArgs: 1,
+		forcible: true, run: showSite},
+	{name: "register", help: "Register those of our rooms that are not registered yet.",
+		mutates: true, needsCallback: true, run: registerSites},
+	{name: "update", help: "Update the registrations of our rooms that differ from our settings.",
+		mutates: true, needsCallback: true, run: updateSites},
+	{name: "delete", args: "id...", help: "Delete the sites with these ids.", minArgs: 1, maxArgs: -1,
+		mutates: true, forcible: true, run: deleteSites},
+	{name: "prune", help: "Delete all but one of each set of sites we own with the same name.",
+		mutates: true, needsCallback: true, run: pruneSites},
+	{name: "doctor", help: "Check for the usual causes of registration problems.",
+		needsCallback: true, run: doctor},
+}
+
+// A parsed subcommand, with its flags and arguments.
+type invocation struct {
+	cmd    *subcommand
+	json   bool
+	dryRun bool
+	force  bool
+	args   []string
+	out    io.Writer
+}
+
+// Returns the subcommand with the given name, or nil.
+func findSubcommand(name string) *subcommand {
+	for _, c := range subcommands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Parses args, which start with the subcommand's name.
+func parseSubcommand(args []string) (inv *invocation, err error) {
+	cmd := findSubcommand(args[0])
+	if cmd == nil {
+		err = ArgError{fmt.Sprintf("Unknown subcommand '%s'.", args[0])}
+		return
+	}
+	inv = &invocation{cmd: cmd, out: os.Stdout}
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.BoolVar(&inv.json, "json", false, "Print JSON rather than text.")
+	if cmd.mutates {
+		fs.BoolVar(&inv.dryRun, "dry-run", false, "Show what would be done without doing it.")
+	}
+	if cmd.forcible {
+		fs.BoolVar(&inv.force, "force", false, "Act on sites that other ids own.")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s: [flags] %s\n%s\n", cmd.name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	err = fs.Parse(args[1:])
+	if err != nil {
+		err = ArgError{fmt.Sprintf("%s: %s", cmd.name, err.Error())}
+		return
+	}
+	inv.args = fs.Args()
+	if len(inv.args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(inv.args) > cmd.maxArgs) {
+		err = ArgError{fmt.Sprintf("Usage: %s [flags] %s", cmd.name, cmd.args)}
+	}
+	return
+}
+
+// Prints how to use the program, including its subcommands.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s: [flags] [subcommand [subcommand flags] [args]]\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "Subcommands (each takes -json; those that change sites take -dry-run,\n"+
+		"and show and delete take -force to act on sites that other ids own):\n")
+	for _, c := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", strings.TrimSpace(c.name+" "+c.args), c.help)
+	}
+}
+
+// Runs a subcommand.
+func runSubcommand(mc *mapclient.Client, inv *invocation) error {
+	checkpoint("CLI", fmt.Sprintf("Begin %s %s", inv.cmd.name, strings.Join(inv.args, " ")))
+	return inv.cmd.run(context.Background(), mc, inv)
+}
+
+// One thing that a subcommand did, or would do given -dry-run.
+type outcome struct {
+	Name    string             `json:"name,omitempty"`
+	Id      string             `json:"id,omitempty"`
+	Action  string             `json:"action"`
+	Changes []mapclient.Change `json:"changes,omitempty"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// Returns a failed outcome.
+func failed(name, id string, err error) outcome {
+	return outcome{Name: name, Id: id, Action: RegFailed, Error: err.Error()}
+}
+
+// Prints v as indented JSON.
+func (inv *invocation) printJSON(v interface{}) error {
+	enc := json.NewEncoder(inv.out)
+	enc.SetIndent("", "    ")
+	return enc.Encode(v)
+}
+
+// Prints what a subcommand did. Returns an error if any of it failed.
+func (inv *invocation) report(outcomes []outcome) (err error) {
+	if outcomes == nil {
+		outcomes = []outcome{}
+	}
+	if inv.json {
+		err = inv.printJSON(outcomes)
+	} else {
+		w := tabwriter.NewWriter(inv.out, 0, 4, 2, ' ', 0)
+		if len(outcomes) == 0 {
+			fmt.Fprintln(w, "Nothing to do.")
+		}
+		for _, o := range outcomes {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", o.Action, o.Name, o.Id)
+			for _, c := range o.Changes {
+				fmt.Fprintf(w, "\t  %s: '%s' -> '%s'\t\n", c.Field, c.Old, c.New)
+			}
+			if len(o.Error) > 0 {
+				fmt.Fprintf(w, "\t  %s\t\n", o.Error)
+			}
+		}
+		err = w.Flush()
+	}
+	if err != nil {
+		return
+	}
+	n := 0
+	for _, o := range outcomes {
+		if o.Action == RegFailed {
+			n++
+		}
+	}
+	if n > 0 {
+		err = RegError{fmt.Sprintf("%s: %d of %d failed.", inv.cmd.name, n, len(outcomes))}
+	}
+	return
+}
+
+// Returns the name and callback target of a site, either of which may
+// be empty.
+func siteNameAndTarget(site *mapclient.Site) (name, target string) {
+	if site.Info == nil {
+		return
+	}
+	name = site.Info.Name
+	if site.Info.ConnectionDetails != nil {
+		target = site.Info.ConnectionDetails.Target
+	}
+	return
+}
+
+// list
+func listSites(ctx context.Context, mc *mapclient.Client, inv *invocation) error {
+	sites, err := listMySites(ctx, mc)
+	if err != nil {
+		return err
+	}
+	if inv.json {
+		if sites == nil {
+			sites = []mapclient.Site{}
+		}
+		return inv.printJSON(sites)
+	}
+	w := tabwriter.NewWriter(inv.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tFULL NAME\tTARGET")
+	for i := range sites {
+		name, target := siteNameAndTarget(&sites[i])
+		var fullName string
+		if sites[i].Info != nil {
+			fullName = sites[i].Info.FullName
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", sites[i].Id, name, fullName, target)
+	}
+	return w.Flush()
+}
+
+// show [-force] id|name
+func showSite(ctx context.Context, mc *mapclient.Client, inv *invocation) error {
+	site, err := findMySite(ctx, mc, inv.args[0])
+	if err == nil {
+		err = inv.checkOwner(site)
+	}
+	if err != nil {
+		return err
+	}
+	if inv.json {
+		return inv.printJSON(site)
+	}
+	info := site.Info
+	if info == nil {
+		info = &mapclient.RoomInfo{}
+	}
+	w := tabwriter.NewWriter(inv.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "id:\t%s\n", site.Id)
+	fmt.Fprintf(w, "rev:\t%s\n", site.Rev)
+	fmt.Fprintf(w, "owner:\t%s\n", site.Owner)
+	fmt.Fprintf(w, "name:\t%s\n", info.Name)
+	fmt.Fprintf(w, "fullName:\t%s\n", info.FullName)
+	fmt.Fprintf(w, "description:\t%s\n", info.Description)
+	if c := info.ConnectionDetails; c != nil {
+		fmt.Fprintf(w, "target:\t%s\n", c.Target)
+	}
+	if d := info.Doors; d != nil {
+		fmt.Fprintf(w, "doors.n:\t%s\n", d.North)
+		fmt.Fprintf(w, "doors.s:\t%s\n", d.South)
+		fmt.Fprintf(w, "doors.e:\t%s\n", d.East)
+		fmt.Fprintf(w, "doors.w:\t%s\n", d.West)
+	}
+	return w.Flush()
+}
+
+// Returns the site whose id, or failing that name, is idOrName.
+func findMySite(ctx context.Context, mc *mapclient.Client, idOrName string) (*mapclient.Site, error) {
+	site, err := mc.Get(ctx, idOrName)
+	if !mapclient.IsNotFound(err) {
+		return site, err
+	}
+	sites, err := mc.List(ctx, mapclient.ListOptions{Owner: config.id, Name: idOrName})
+	if err != nil {
+		return nil, err
+	}
+	if len(sites) == 0 {
+		return nil, RegError{fmt.Sprintf("There is no site with id or name '%s'.", idOrName)}
+	}
+	return &sites[0], nil
+}
+
+// Returns an error if some other id owns site, unless given -force.
+func (inv *invocation) checkOwner(site *mapclient.Site) error {
+	if site.Owner == config.id || inv.force {
+		return nil
+	}
+	return RegError{fmt.Sprintf("Site %s is owned by '%s', not us; use -force to %s it anyway.",
+		site.Id, site.Owner, inv.cmd.name)}
+}
+
+// register [-dry-run]
+func registerSites(ctx context.Context, mc *mapclient.Client, inv *invocation) error {
+	var outcomes []outcome
+	for _, spec := range roomSpecs {
+		site, err := checkForPriorRegistration(ctx, mc, spec)
+		switch {
+		case err != nil:
+			outcomes = append(outcomes, failed(spec.Name, "", err))
+		case site != nil:
+			outcomes = append(outcomes, outcome{Name: spec.Name, Id: site.Id, Action: "already registered"})
+		case inv.dryRun:
+			outcomes = append(outcomes, outcome{Name: spec.Name, Action: "would register"})
+		default:
+			err = registerOurRoom(ctx, mc, spec)
+			if err != nil {
+				outcomes = append(outcomes, failed(spec.Name, "", err))
+				continue
+			}
+			outcomes = append(outcomes, outcome{Name: spec.Name, Id: rememberedRegistration(spec.Name).Id,
+				Action: RegRegistered})
+		}
+	}
+	return inv.report(outcomes)
+}
+
+// update [-dry-run]
+func updateSites(ctx context.Context, mc *mapclient.Client, inv *invocation) error {
+	var outcomes []outcome
+	for _, spec := range roomSpecs {
+		site, err := checkForPriorRegistration(ctx, mc, spec)
+		if err != nil {
+			outcomes = append(outcomes, failed(spec.Name, "", err))
+			continue
+		}
+		if site == nil {
+			outcomes = append(outcomes, outcome{Name: spec.Name, Action: "not registered"})
+			continue
+		}
+		o := outcome{Name: spec.Name, Id: site.Id, Action: RegUnchanged}
+		if inv.dryRun {
+			_, _, o.Changes, err = compareRegistration(ctx, mc, spec, site.Id)
+			if len(o.Changes) > 0 {
+				o.Action = "would update"
+			}
+		} else {
+			o.Changes, err = updateOurRoom(ctx, mc, spec, site.Id)
+			if len(o.Changes) > 0 {
+				o.Action = RegUpdated
+			}
+		}
+		if err != nil {
+			o.Action = RegFailed
+			o.Error = err.Error()
+		}
+		outcomes = append(outcomes, o)
+	}
+	return inv.report(outcomes)
+}
+
+// delete [-dry-run] [-force] id...
+func deleteSites(ctx context.Context, mc *mapclient.Client, inv *invocation) error {
+	var outcomes []outcome
+	for _, id := range inv.args {
+		site, err := mc.Get(ctx, id)
+		if err == nil {
+			err = inv.checkOwner(site)
+		}
+		if err != nil {
+			outcomes = append(outcomes, failed("", id, err))
+			continue
+		}
+		name, _ := siteNameAndTarget(site)
+		outcomes = append(outcomes, deleteOrNot(ctx, mc, inv, name, id))
+	}
+	return inv.report(outcomes)
+}
+
+// Deletes the site with the given id, unless this is a dry run.
+func deleteOrNot(ctx context.Context, mc *mapclient.Client, inv *invocation, name, id string) outcome {
+	if inv.dryRun {
+		return outcome{Name: name, Id: id, Action: "would delete"}
+	}
+	err := deleteRoom(ctx, mc, id)
+	if err != nil {
+		return failed(name, id, err)
+	}
+	return outcome{Name: name, Id: id, Action: "deleted"}
+}
+
+// prune [-dry-run]
+//
+// Of each set of sites that we own with the same name, keeps the
+// first whose callback is ours, or the first of all if none is, and
+// deletes the rest.
+func pruneSites(ctx context.Context, mc *mapclient.Client, inv *invocation) error {
+	sites, err := listMySites(ctx, mc)
+	if err != nil {
+		return err
+	}
+	var names []string
+	byName := make(map[string][]*mapclient.Site)
+	for i := range sites {
+		name, _ := siteNameAndTarget(&sites[i])
+		if byName[name] == nil {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], &sites[i])
+	}
+	var outcomes []outcome
+	for _, name := range names {
+		dups := byName[name]
+		if len(dups) < 2 {
+			continue
+		}
+		keep := 0
+		for i, site := range dups {
+			if _, target := siteNameAndTarget(site); isOurTarget(target) {
+				keep = i
+				break
+			}
+		}
+		for i, site := range dups {
+			if i == keep {
+				outcomes = append(outcomes, outcome{Name: name, Id: site.Id, Action: "kept"})
+				continue
+			}
+			outcomes = append(outcomes, deleteOrNot(ctx, mc, inv, name, site.Id))
+		}
+	}
+	return inv.report(outcomes)
+}
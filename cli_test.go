@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sample-room-golang/gameon/mapclient"
+	"testing"
+)
+
+func TestParseSubcommand(t *testing.T) {
+	inv, err := parseSubcommand([]string{"delete", "-dry-run", "-json", "a", "b"})
+	if err != nil {
+		t.Fatalf("parseSubcommand failed: %v", err)
+	}
+	if inv.cmd.name != "delete" || !inv.dryRun || !inv.json || len(inv.args) != 2 {
+		t.Errorf("Unexpected invocation %+v", inv)
+	}
+	bad := [][]string{
+		{"frobnicate"},
+		{"show"},
+		{"show", "a", "b"},
+		{"list", "-dry-run"},
+	}
+	for _, args := range bad {
+		if _, err := parseSubcommand(args); err == nil {
+			t.Errorf("%v was accepted.", args)
+		}
+	}
+}
+
+func TestPruneSites(t *testing.T) {
+	config.callbackScheme = "ws"
+	config.callbackAddr = "10.0.0.1"
+	config.callbackPort = 3000
+	config.callbackPath = "/ws"
+	site := func(id, name, target string) mapclient.Site {
+		return mapclient.Site{Id: id, Info: &mapclient.RoomInfo{Name: name,
+			ConnectionDetails: &mapclient.ConnectionDetails{Target: target}}}
+	}
+	sites := []mapclient.Site{
+		site("a", "ROOM.1", "ws://10.0.0.2:3000/ws"),
+		site("b", "ROOM.1", callbackTarget()),
+		site("c", "ROOM.1", "ws://10.0.0.3:3000/ws"),
+		site("d", "ROOM.2", "ws://10.0.0.2:3000/ws"),
+	}
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode(sites)
+		case "DELETE":
+			deleted = append(deleted, r.URL.Path[len("/map/v1/sites/"):])
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	mc := mapclient.New(srv.Client(), srv.URL+"/map/v1", "owner", "secret")
+
+	var out bytes.Buffer
+	inv := &invocation{cmd: findSubcommand("prune"), dryRun: true, out: &out}
+	if err := pruneSites(context.Background(), mc, inv); err != nil {
+		t.Fatalf("prune -dry-run failed: %v", err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("prune -dry-run deleted %v", deleted)
+	}
+
+	inv.dryRun = false
+	inv.json = true
+	out.Reset()
+	if err := pruneSites(context.Background(), mc, inv); err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if len(deleted) != 2 || deleted[0] != "a" || deleted[1] != "c" {
+		t.Errorf("prune deleted %v, not [a c]", deleted)
+	}
+	var outcomes []outcome
+	if err := json.Unmarshal(out.Bytes(), &outcomes); err != nil {
+		t.Fatalf("prune -json printed %q: %v", out.String(), err)
+	}
+	if len(outcomes) != 3 || outcomes[1].Id != "b" || outcomes[1].Action != "kept" {
+		t.Errorf("Unexpected outcomes %+v", outcomes)
+	}
+}
+
+func TestDeleteChecksOwner(t *testing.T) {
+	config.id = "owner"
+	sites := map[string]mapclient.Site{
+		"a": {Id: "a", Owner: "owner", Info: &mapclient.RoomInfo{Name: "ROOM.1"}},
+		"b": {Id: "b", Owner: "someone else", Info: &mapclient.RoomInfo{Name: "ROOM.2"}},
+	}
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path[len("/map/v1/sites/"):]
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode(sites[id])
+		case "DELETE":
+			deleted = append(deleted, id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	mc := mapclient.New(srv.Client(), srv.URL+"/map/v1", "owner", "secret")
+
+	var out bytes.Buffer
+	inv := &invocation{cmd: findSubcommand("delete"), args: []string{"a", "b"}, out: &out}
+	if err := deleteSites(context.Background(), mc, inv); err == nil {
+		t.Errorf("delete of another owner's site succeeded")
+	}
+	if len(deleted) != 1 || deleted[0] != "a" {
+		t.Errorf("delete deleted %v, not [a]", deleted)
+	}
+
+	deleted = nil
+	inv.args = []string{"b"}
+	inv.force = true
+	if err := deleteSites(context.Background(), mc, inv); err != nil {
+		t.Errorf("delete -force failed: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "b" {
+		t.Errorf("delete -force deleted %v, not [b]", deleted)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Clock skew calibration
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The map service refuses signed requests whose gameon-date is too far
+// from its own clock. Rather than have everyone measure the difference
+// and set -ts by hand, with -autoSkew (the default) we measure it from
+// the Date header of every map service response and makeTimestamp
+// corrects for it. -ts, if given, is applied on top. A signed request
+// that is refused for its date anyway is signed again and retried once
+// by the map client (see mapclient.IsExpired).
+
+// A SkewMeter tracks how far the map service's clock is ahead of ours.
+type SkewMeter struct {
+	mu       sync.Mutex
+	offset   time.Duration
+	measured bool
+}
+
+var clockSkew SkewMeter
+
+// Returns the measured offset, or 0 if none has been measured.
+func (m *SkewMeter) get() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.offset
+}
+
+// Takes the map service's clock, date, as read at some point between
+// sent and received into account.
+func (m *SkewMeter) observe(date, sent, received time.Time) {
+	rtt := received.Sub(sent)
+	// Date has whole seconds, so the map service's clock read between
+	// date and date+1s; assume the middle of that, and that it was
+	// read half way through the round trip.
+	estimate := date.Add(500 * time.Millisecond).Sub(sent.Add(rtt / 2))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	diff := estimate - m.offset
+	if diff < 0 {
+		diff = -diff
+	}
+	// Within the resolution of the Date header one estimate is as
+	// good as another, so keep the one we have.
+	if m.measured && diff <= time.Second+rtt/2 {
+		return
+	}
+	m.offset = estimate
+	m.measured = true
+	clockSkewSeconds.Set(estimate.Seconds())
+	checkpoint("CLOCK.SKEW", fmt.Sprintf("The map service's clock is %v ahead of ours.", estimate.Round(time.Millisecond)))
+}
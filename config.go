@@ -6,9 +6,11 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"strings"
 )
 
 const localSecret = "<LocalSecret>"
@@ -47,17 +49,128 @@ type RoomConfig struct {
 	// This is the shared secret that was obtained during your GameOn!
 	// browser login. If you logged in using your Google ID it might
 	// look like this: 'LNIkaoiu62addlGp/rCZc7g,n3s9jUtOpXErr062kos='
-	secret         string
-	localServer    bool
-	timeShift      int
+	// It may instead be read from secretFile, which is polled every
+	// secretPoll seconds for a new secret; the old one is accepted
+	// for secretGrace seconds after a change. See secret.go.
+	secret      string
+	secretFile  string
+	secretPoll  int
+	secretGrace int
+	localServer bool
+	timeShift   int
+	// If true we measure the map service's clock and correct our
+	// timestamps to match. See clock.go.
+	autoSkew bool
+	// Calls to the map service are attempted up to retries times,
+	// waiting secondsBetween after the first failure and doubling
+	// that, up to maxBetween, after each one. Each attempt may take
+	// up to mapTimeout seconds.
 	retries        int
 	secondsBetween int
+	maxBetween     int
+	mapTimeout     int
+	// If true, a registration that differs from our settings is left
+	// alone rather than updated.
+	noUpdate bool
+	// The number of seconds between checks that our registrations
+	// still exist and match our settings, or 0 for no checks. See
+	// watchdog.go.
+	watchInterval int
+	// The number of seconds between refreshes of our rooms' exits, or
+	// 0 to fetch them only at startup. See exits.go.
+	exitsInterval int
+	// A file listing the rooms to register and serve, in place of
+	// roomName and the door descriptions. See manifest.go.
+	manifest string
+	// The websocket scheme (ws or wss) and path that Game On! should
+	// use to reach us, which behind a TLS-terminating ingress may
+	// differ from what we serve ourselves.
+	callbackScheme string
+	callbackPath   string
+	// If tlsCert and tlsKey are given we serve TLS, no older than
+	// tlsMinVersion, with the configuration in serveTLS, and reload
+	// the pair every certPoll seconds if it has changed. See certs.go.
+	tlsCert       string
+	tlsKey        string
+	tlsMinVersion string
+	certPoll      int
+	serveTLS      *tls.Config
+	// Our connection token, read from (or generated into) tokenFile.
+	// See token.go.
+	tokenFile string
+	token     string
+	// Comma-separated networks that may use /ws, /metrics, /health
+	// and the admin routes, and origins that may open a websocket.
+	// Empty lists let everyone in. See access.go.
+	wsAllow      string
+	metricsAllow string
+	healthAllow  string
+	adminAllow   string
+	wsOrigins    string
 	// This is a room id and it is only used in the context of a
 	// delete request.
 	roomToDelete string
+	// The subcommand to run instead of serving rooms, if any. See
+	// cli.go.
+	invocation *invocation
+	// How we check the map service's certificate and which one we
+	// present to it, and the TLS configuration that results. See
+	// trust.go.
+	caFile     string
+	clientCert string
+	clientKey  string
+	pins       string
+	insecure   bool
+	mapTLS     *tls.Config
 	// The protocol to be used when talking to the game server.
 	protocol                       string
 	maxSecondsBetweenConversations int
+	// The number of seconds that the gameon-date of an incoming
+	// websocket handshake may differ from our own clock.
+	handshakeSkew int
+	// The number of outbound messages that may be queued for a
+	// websocket connection, and what to do when that queue is full.
+	sendQueueSize int
+	slowConsumer  string
+	// Keepalive settings, in seconds. We ping the mediator and give
+	// up on a connection if nothing, not even a pong, arrives within
+	// pongWait. A connection that carries no messages for idleTimeout
+	// is closed (zero disables this), and a parted player who has not
+	// rejoined within playerTTL is forgotten (zero forgets them at
+	// once).
+	pongWait    int
+	writeWait   int
+	idleTimeout int
+	playerTTL   int
+	// Shutdown behaviour. If shutdownExit is set players are moved
+	// out through that door; each connection gets drainSeconds to
+	// deliver what is queued; if deregister is true our sites are
+	// deleted from the Game On! map.
+	shutdownExit string
+	drainSeconds int
+	deregister   bool
+	// Flood control. Each player may make requests of each class at
+	// xxxRate per second, with bursts of up to xxxBurst; a rate of 0
+	// means no limit. A player refused muteAfter times in a row is
+	// muted for muteSeconds; muteAfter 0 disables muting.
+	chatRate       float64
+	chatBurst      int
+	commandRate    float64
+	commandBurst   int
+	expensiveRate  float64
+	expensiveBurst int
+	muteAfter      int
+	muteSeconds    int
+	// Changes to the rules by which our log is redacted, and whether
+	// masked fields such as chat content are logged in full. See
+	// redact.go.
+	redact  string
+	verbose bool
+	// The largest websocket message, in bytes, that we will read, and
+	// the number of malformed messages after which a connection is
+	// closed (0 means never).
+	maxMessageSize int
+	maxMalformed   int
 }
 
 // config is our single, package-wide, source of configuration data.
@@ -69,12 +182,17 @@ var config RoomConfig
 //
 // Returns nil if successful or an error otherwise
 func processCommandline() (err error) {
+	flag.Usage = usage
 	flag.StringVar(&config.gameonAddr, "g", "", "GameOn! server address")
 	flag.StringVar(&config.callbackAddr, "c", "", "Our published callback address")
 	flag.IntVar(&config.callbackPort, "cp", -1, "Our published callback port")
 	flag.IntVar(&config.listeningPort, "lp", -1, "Our listening port")
 	flag.StringVar(&config.roomName, "r", "", "Our room name.")
 	flag.BoolVar(&config.debug, "d", false, "Enables debug mode")
+	flag.BoolVar(&config.verbose, "verbose", false,
+		"Log chat content and other masked fields in full. User ids are still hashed.")
+	flag.StringVar(&config.redact, "redact", "",
+		"Comma-separated field=rule changes to how the log is redacted, where rule is keep, hash, mask or strip.")
 	flag.StringVar(&config.north, "north", "A frost-covered door leads to the south.", "Describes the outside of our northern door")
 	flag.StringVar(&config.south, "south", "A moss-covered door leads to the north", "Describes the outside of our southern door")
 	flag.StringVar(&config.east, "east", "A badly-painted door opens to the west.", "Describes the outside of our eastern door")
@@ -82,21 +200,133 @@ func processCommandline() (err error) {
 	flag.StringVar(&config.up, "down", "There is a rickety set of steps leading up.", "Describes the outside/bottom of door in the floor. GameOn! often ignores this door")
 	flag.StringVar(&config.down, "up", "Heat eminates from an opening in the floor.", "Describes the outside/top of the hatch in the ceiling. GameOn! often ignores this door")
 	flag.StringVar(&config.id, "id", "", "The id associated with our shared secret.")
-	flag.StringVar(&config.secret, "secret", localSecret, "Our shared secret.")
+	flag.StringVar(&config.secret, "secret", localSecret,
+		"Our shared secret. Prefer -secretFile or the "+secretEnv+" environment variable, which ps does not show.")
+	flag.StringVar(&config.secretFile, "secretFile", "", "A file holding our shared secret.")
+	flag.IntVar(&config.secretPoll, "secretPoll", 30,
+		"The number of seconds between checks of -secretFile for a new secret (0 disables).")
+	flag.IntVar(&config.secretGrace, "secretGrace", 300,
+		"The number of seconds for which handshakes signed with the previous secret are accepted after a rotation.")
+	flag.StringVar(&config.caFile, "caFile", "",
+		"A PEM file of CA certificates to trust for the map service, in addition to the system's.")
+	flag.StringVar(&config.clientCert, "clientCert", "",
+		"A PEM certificate to present to the map service, for mutual TLS.")
+	flag.StringVar(&config.clientKey, "clientKey", "", "The PEM private key of -clientCert.")
+	flag.StringVar(&config.pins, "pin", "",
+		"Comma-separated base64 SHA-256 hashes of public keys, one of which the map service's certificate chain must have.")
+	flag.BoolVar(&config.insecure, "insecure", false,
+		"Do not check the map service's certificate. Only for local testing.")
 	flag.BoolVar(&config.localServer, "local", false, "We are using a local server. Local servers expect http://; remote servers expect https://")
+	flag.BoolVar(&config.autoSkew, "autoSkew", true,
+		"Measure the map service's clock and correct our timestamps to match (in addition to -ts).")
 	flag.IntVar(&config.timeShift, "ts", 0, "The number of milleseconds to add or subtract from our timestamp so that we can better match the server clock")
 	flag.IntVar(&config.retries, "retries", 5, "The number of initial registration attempts.")
 	flag.IntVar(&config.secondsBetween, "between", 5, "The number of seconds between registration attempts.")
+	flag.IntVar(&config.maxBetween, "maxBetween", 60,
+		"The most seconds between registration attempts as the wait between them grows.")
+	flag.IntVar(&config.mapTimeout, "mapTimeout", 30,
+		"The number of seconds a single request to the map service may take.")
+	flag.BoolVar(&config.noUpdate, "noupdate", false,
+		"Do not update our registration if it differs from our settings.")
+	flag.IntVar(&config.exitsInterval, "exitsInterval", 600,
+		"The number of seconds between refreshes of our rooms' exits (0 fetches them only at startup).")
+	flag.IntVar(&config.watchInterval, "watchInterval", 300,
+		"The number of seconds between checks that our registrations still exist and match our settings (0 disables).")
+	flag.StringVar(&config.callbackScheme, "cs", "ws", "Our published callback scheme, ws or wss")
+	flag.StringVar(&config.callbackPath, "cpath", "/ws", "Our published callback path")
+	flag.StringVar(&config.tlsCert, "tlsCert", "",
+		"A PEM certificate with which to serve HTTPS and WSS ourselves; -cs then defaults to wss.")
+	flag.StringVar(&config.tlsKey, "tlsKey", "", "The PEM private key of -tlsCert.")
+	flag.StringVar(&config.tlsMinVersion, "tlsMinVersion", "1.2",
+		"The oldest TLS version we accept when serving TLS: 1.0, 1.1, 1.2 or, built with Go 1.12 or later, 1.3.")
+	flag.IntVar(&config.certPoll, "certPoll", 60,
+		"The number of seconds between checks of -tlsCert and -tlsKey for a new certificate (0 disables).")
+	flag.StringVar(&config.tokenFile, "tokenFile", "",
+		"Register the connection token in this file, generating it if the file does not exist.")
+	flag.StringVar(&config.wsAllow, "wsAllow", "",
+		"Comma-separated addresses and CIDRs that may open our websocket (empty allows all).")
+	flag.StringVar(&config.metricsAllow, "metricsAllow", "",
+		"Comma-separated addresses and CIDRs that may read /metrics (empty allows all).")
+	flag.StringVar(&config.healthAllow, "healthAllow", "",
+		"Comma-separated addresses and CIDRs that may read /health (empty allows all).")
+	flag.StringVar(&config.adminAllow, "adminAllow", "",
+		"Comma-separated addresses and CIDRs that may use admin routes such as /status/registration (empty allows all).")
+	flag.StringVar(&config.wsOrigins, "wsOrigins", "",
+		"Comma-separated origins, which may contain *, from which browsers may open our websocket (empty allows all).")
+	flag.StringVar(&config.manifest, "manifest", "",
+		"A YAML or JSON file listing the rooms to serve, in place of -r and the door flags.")
 	flag.StringVar(&config.roomToDelete, "delete", "", "Delete the room with this id and exit.")
 	flag.IntVar(&config.maxSecondsBetweenConversations, "quietTime", 60,
 		"The maimum number of seconds between randomly injected conversations.")
+	flag.IntVar(&config.handshakeSkew, "handshakeSkew", 300,
+		"The number of seconds a websocket handshake date may differ from our clock.")
+	flag.IntVar(&config.sendQueueSize, "sendQueue", 64,
+		"The number of outbound messages that may be queued for each websocket connection.")
+	flag.StringVar(&config.slowConsumer, "slowConsumer", SlowConsumerDrop,
+		"What to do when a connection's outbound queue is full: drop, block or disconnect.")
+	flag.IntVar(&config.pongWait, "pongWait", 60,
+		"The number of seconds to wait for a pong before dropping a websocket connection.")
+	flag.IntVar(&config.writeWait, "writeWait", 10,
+		"The number of seconds a single websocket write may take.")
+	flag.IntVar(&config.idleTimeout, "idleTimeout", 1800,
+		"Close websocket connections that carry no messages for this many seconds (0 disables).")
+	flag.IntVar(&config.playerTTL, "playerTTL", 3600,
+		"Forget parted players who have not rejoined within this many seconds (0 forgets them at once).")
+	flag.StringVar(&config.shutdownExit, "shutdownExit", "",
+		"On shutdown, move players out through this door (n, s, e or w).")
+	flag.IntVar(&config.drainSeconds, "drainSeconds", 10,
+		"On shutdown, the number of seconds allowed for queued messages to be delivered.")
+	flag.BoolVar(&config.deregister, "deregister", false,
+		"On shutdown, delete our room registrations.")
+	flag.Float64Var(&config.chatRate, "chatRate", 1,
+		"The number of chat messages per second each player may send (0 disables the limit).")
+	flag.IntVar(&config.chatBurst, "chatBurst", 5,
+		"The number of chat messages each player may send in a burst.")
+	flag.Float64Var(&config.commandRate, "commandRate", 2,
+		"The number of slash commands per second each player may send (0 disables the limit).")
+	flag.IntVar(&config.commandBurst, "commandBurst", 10,
+		"The number of slash commands each player may send in a burst.")
+	flag.Float64Var(&config.expensiveRate, "expensiveRate", 0.2,
+		"The number of expensive commands (/look, /inventory) per second each player may send (0 disables the limit).")
+	flag.IntVar(&config.expensiveBurst, "expensiveBurst", 2,
+		"The number of expensive commands each player may send in a burst.")
+	flag.IntVar(&config.muteAfter, "muteAfter", 0,
+		"Mute a player whose requests are refused this many times in a row (0 disables muting).")
+	flag.IntVar(&config.muteSeconds, "muteSeconds", 60,
+		"The number of seconds for which a flooding player is muted.")
+	flag.IntVar(&config.maxMessageSize, "maxMessage", 8192,
+		"The largest websocket message, in bytes, that we will accept.")
+	flag.IntVar(&config.maxMalformed, "maxMalformed", 10,
+		"Close a websocket connection once it has sent this many malformed messages (0 disables).")
 
 	flag.Parse()
+	err = loadRedaction()
+	if err != nil {
+		return
+	}
+	if flag.NArg() > 0 {
+		config.invocation, err = parseSubcommand(flag.Args())
+		if err != nil {
+			return
+		}
+	}
 	if config.gameonAddr == "" {
 		err = ArgError{"Missing Game-on server address."}
 		return
 	}
-	if len(config.roomToDelete) == 0 {
+	err = loadSecret()
+	if err != nil {
+		return
+	}
+	config.mapTLS, err = mapServiceTLS()
+	if err != nil {
+		return
+	}
+	err = loadAccessPolicies()
+	if err != nil {
+		return
+	}
+	if needCallback() {
 		// This is not a deletion request so make sure the information
 		// we need to register a room and run the websocket server is valid.
 		if config.callbackAddr == "" {
@@ -107,6 +337,26 @@ func processCommandline() (err error) {
 			err = ArgError{"Missing or invalid callback port."}
 			return
 		}
+		config.serveTLS, err = servingTLS()
+		if err != nil {
+			return
+		}
+		if config.serveTLS != nil && !flagGiven("cs") {
+			config.callbackScheme = "wss"
+		}
+		if config.callbackScheme != "ws" && config.callbackScheme != "wss" {
+			err = ArgError{fmt.Sprintf("Invalid callback scheme '%s'.", config.callbackScheme)}
+			return
+		}
+		if !strings.HasPrefix(config.callbackPath, "/") {
+			config.callbackPath = "/" + config.callbackPath
+		}
+		if len(config.tokenFile) > 0 {
+			config.token, err = loadOrCreateToken(config.tokenFile)
+			if err != nil {
+				return
+			}
+		}
 		if config.listeningPort < 0 {
 			// listening port defaults to callback port
 			config.listeningPort = config.callbackPort
@@ -114,6 +364,69 @@ func processCommandline() (err error) {
 		if config.roomName == "" {
 			config.roomName = fmt.Sprintf("ROOM.%05d", config.callbackPort)
 		}
+		if len(config.manifest) > 0 {
+			roomSpecs, err = loadManifest(config.manifest)
+			if err != nil {
+				return
+			}
+		} else {
+			roomSpecs = []*roomSpec{flagRoomSpec()}
+		}
+	}
+	if config.handshakeSkew < 1 {
+		err = ArgError{"handshakeSkew must be at least 1 second."}
+		return
+	}
+	if config.sendQueueSize < 1 {
+		err = ArgError{"The outbound queue size must be at least 1."}
+		return
+	}
+	if config.pongWait < 2 || config.writeWait < 1 {
+		err = ArgError{"pongWait must be at least 2 seconds and writeWait at least 1."}
+		return
+	}
+	switch config.shutdownExit {
+	case "", "n", "s", "e", "w":
+	default:
+		err = ArgError{fmt.Sprintf("Invalid shutdown exit '%s'.", config.shutdownExit)}
+		return
+	}
+	if config.chatRate < 0 || config.commandRate < 0 || config.expensiveRate < 0 {
+		err = ArgError{"Rate limits must not be negative."}
+		return
+	}
+	if (config.chatRate > 0 && config.chatBurst < 1) ||
+		(config.commandRate > 0 && config.commandBurst < 1) ||
+		(config.expensiveRate > 0 && config.expensiveBurst < 1) {
+		err = ArgError{"Rate limit bursts must be at least 1."}
+		return
+	}
+	if config.muteAfter < 0 || config.muteSeconds < 1 {
+		err = ArgError{"muteAfter must not be negative and muteSeconds must be at least 1."}
+		return
+	}
+	if config.retries < 1 || config.secondsBetween < 0 {
+		err = ArgError{"retries must be at least 1 and between must not be negative."}
+		return
+	}
+	if config.maxBetween < config.secondsBetween {
+		config.maxBetween = config.secondsBetween
+	}
+	if config.watchInterval < 0 || config.exitsInterval < 0 {
+		err = ArgError{"watchInterval and exitsInterval must not be negative."}
+		return
+	}
+	if config.mapTimeout < 1 {
+		err = ArgError{"mapTimeout must be at least 1 second."}
+		return
+	}
+	if config.maxMessageSize < 512 {
+		err = ArgError{"The maximum message size must be at least 512 bytes."}
+		return
+	}
+	if !isSlowConsumerPolicy(config.slowConsumer) {
+		err = ArgError{fmt.Sprintf("Unknown slow consumer policy '%s'.", config.slowConsumer)}
+		return
 	}
 	if config.localServer {
 		config.protocol = "http"
@@ -123,25 +436,74 @@ func processCommandline() (err error) {
 	return
 }
 
+// Reports whether the flag with the given name was on the command line.
+func flagGiven(name string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// Reports whether we need our rooms and callback address, which we do
+// unless we are only deleting or looking at registrations.
+func needCallback() bool {
+	if len(config.roomToDelete) > 0 {
+		return false
+	}
+	return config.invocation == nil || config.invocation.cmd.needsCallback
+}
+
 func printConfig(c *RoomConfig) {
 	log.Printf("gameonAddr=%s\n", config.gameonAddr)
 	// Many things are useless when we are just doing a delete.
-	if config.roomToDelete == "" {
+	if needCallback() {
 		log.Printf("callbackAddr=%s\n", config.callbackAddr)
 		log.Printf("callbackPort=%d\n", config.callbackPort)
+		log.Printf("callbackTarget=%s\n", callbackTarget())
+		log.Printf("tokenFile=%s\n", config.tokenFile)
+		log.Printf("wsAllow=%s wsOrigins=%s\n", config.wsAllow, config.wsOrigins)
+		log.Printf("metricsAllow=%s healthAllow=%s adminAllow=%s\n",
+			config.metricsAllow, config.healthAllow, config.adminAllow)
+		log.Printf("tlsCert=%s tlsMinVersion=%s certPoll=%d\n",
+			config.tlsCert, config.tlsMinVersion, config.certPoll)
 		log.Printf("listeningPort=%d\n", config.listeningPort)
-		log.Printf("roomName=%s\n", config.roomName)
-		log.Printf("north=%s\n", config.north)
-		log.Printf("south=%s\n", config.south)
-		log.Printf("east=%s\n", config.east)
-		log.Printf("west=%s\n", config.west)
+		if len(config.manifest) > 0 {
+			log.Printf("manifest=%s\n", config.manifest)
+		}
+		for _, spec := range roomSpecs {
+			log.Printf("roomName=%s\n", spec.Name)
+			log.Printf("north=%s\n", spec.Doors.North)
+			log.Printf("south=%s\n", spec.Doors.South)
+			log.Printf("east=%s\n", spec.Doors.East)
+			log.Printf("west=%s\n", spec.Doors.West)
+		}
 	}
-	log.Printf("debug=%v\n", config.debug)
+	log.Printf("debug=%v verbose=%v redact=%s\n", config.debug, config.verbose, config.redact)
 	log.Printf("roomToDelete=%v\n", config.roomToDelete)
 	log.Printf("localServer=%v\n", config.localServer)
-	log.Printf("timeShift=%d\n", config.timeShift)
+	log.Printf("timeShift=%d autoSkew=%v\n", config.timeShift, config.autoSkew)
+	log.Printf("retries=%d between=%d maxBetween=%d mapTimeout=%d\n",
+		config.retries, config.secondsBetween, config.maxBetween, config.mapTimeout)
+	log.Printf("noupdate=%v watchInterval=%d exitsInterval=%d\n",
+		config.noUpdate, config.watchInterval, config.exitsInterval)
+	log.Printf("handshakeSkew=%d\n", config.handshakeSkew)
+	log.Printf("sendQueue=%d slowConsumer=%s\n", config.sendQueueSize, config.slowConsumer)
+	log.Printf("pongWait=%d writeWait=%d idleTimeout=%d playerTTL=%d\n",
+		config.pongWait, config.writeWait, config.idleTimeout, config.playerTTL)
+	log.Printf("shutdownExit=%s drainSeconds=%d deregister=%v\n",
+		config.shutdownExit, config.drainSeconds, config.deregister)
+	log.Printf("chatRate=%g/%d commandRate=%g/%d expensiveRate=%g/%d muteAfter=%d muteSeconds=%d\n",
+		config.chatRate, config.chatBurst, config.commandRate, config.commandBurst,
+		config.expensiveRate, config.expensiveBurst, config.muteAfter, config.muteSeconds)
+	log.Printf("maxMessage=%d maxMalformed=%d\n", config.maxMessageSize, config.maxMalformed)
+	log.Printf("caFile=%s clientCert=%s pin=%s insecure=%v\n",
+		config.caFile, config.clientCert, config.pins, config.insecure)
+	log.Printf("secretFile=%s secretPoll=%d secretGrace=%d\n",
+		config.secretFile, config.secretPoll, config.secretGrace)
 	if config.debug {
 		log.Printf("id=%s\n", config.id)
-		log.Printf("secret=%s\n", config.secret)
 	}
 }
@@ -5,18 +5,61 @@
 #   CONTAINER_IP  - The public IP address to which your container is bound.
 #                   (This is needed for the websocket callback.)
 #   GAMEON_ID     - The ID given in Game On!
-#   GAMEON_SECRET - The shared secret given in Game On!
+#   GAMEON_SECRET - The shared secret given in Game On! The room reads
+#                   it from its environment, so it is not on the
+#                   command line for ps to show.
 #   ROOM_NAME     - Your name for the room.
 #
+# Instead of GAMEON_SECRET you may set:
+#   GAMEON_SECRET_FILE - A file holding the shared secret, such as a
+#                   mounted Kubernetes Secret. The room notices when it
+#                   changes and switches to the new secret.
+#
 # The following environment variables are optional:
 #   GAMEON_ADDR   - The game server address, defaults to gameontext.org.
 #   GAMEON_PORT   - Our external port, defaults to 3000.
 #                   (This is needed for the websocket callback.)
 #   GAMEON_DEBUG  - Any non-empty value turns on debug output
+#   GAMEON_VERBOSE - Any non-empty value logs chat content in full.
+#                   User ids and usernames are still hashed.
+#   GAMEON_REDACT - Changes to how the log is redacted, for example
+#                   username=keep,content=hash.
+#   GAMEON_CALLBACK_SCHEME - ws or wss, defaults to ws, or to wss if
+#                   GAMEON_TLS_CERT is set. Use wss when the room is
+#                   published through a TLS-terminating ingress.
+#   GAMEON_TLS_CERT, GAMEON_TLS_KEY - A PEM certificate and key with
+#                   which the room serves TLS itself. A renewed pair
+#                   is picked up without a restart.
+#   GAMEON_TLS_MIN_VERSION - The oldest TLS version accepted, defaults
+#                   to 1.2.
+#   GAMEON_CALLBACK_PATH - The published websocket path, defaults to /ws.
+#   GAMEON_TOKEN_FILE - If set, a connection token is kept in this file
+#                   (and generated if need be) and registered with
+#                   the room. Put it on a volume so it survives restarts.
 #   GAMEON_TIMESHIFT - Use this to adjust our timestamps to match the
 #                      GameOn! server in cases where the time is skewed
 #                      relative to our room and the server. This is
 #                      expressed in milliseconds. The default is 0.
+#                      The room measures and corrects for skew by
+#                      itself, so this is rarely needed; it is applied
+#                      on top of the measured skew.
+#   GAMEON_AUTOSKEW - Set to false to stop the room correcting its
+#                     timestamps for the skew it measures.
+#   GAMEON_CA_FILE - A PEM file of CA certificates to trust for the map
+#                    service, in addition to the system's.
+#   GAMEON_CLIENT_CERT, GAMEON_CLIENT_KEY - A PEM certificate and key
+#                    to present to the map service, for mutual TLS.
+#   GAMEON_PINS    - Comma-separated base64 SHA-256 hashes of public keys,
+#                    one of which the map service's certificates must have.
+#   GAMEON_WS_ALLOW, GAMEON_METRICS_ALLOW, GAMEON_HEALTH_ALLOW,
+#   GAMEON_ADMIN_ALLOW - Comma-separated addresses and CIDRs that may
+#                    use /ws, /metrics, /health and the admin routes.
+#                    Unset allows everyone.
+#   GAMEON_WS_ORIGINS - Comma-separated origins from which browsers may
+#                    open our websocket. Unset allows any.
+#   GAMEON_SECRET_GRACE - The number of seconds for which handshakes
+#                     signed with the previous secret are accepted
+#                     after GAMEON_SECRET_FILE changes. Defaults to 300.
 #
 #   The following two variables allow for a delay in network connectivity.
 #
@@ -45,7 +88,12 @@ function assert_var_set
 # Make sure the required env vars are defined with non-empty values
 assert_var_set CONTAINER_IP $CONTAINER_IP
 assert_var_set GAMEON_ID $GAMEON_ID
-assert_var_set GAMEON_SECRET $GAMEON_SECRET
+if [ -z "$GAMEON_SECRET_FILE" ] ; then
+    assert_var_set GAMEON_SECRET $GAMEON_SECRET
+    SECRET_FLAG=""
+else
+    SECRET_FLAG="-secretFile $GAMEON_SECRET_FILE"
+fi
 assert_var_set ROOM_NAME $ROOM_NAME
 
 # Make sure any optional env vars are given default values if they are not defined
@@ -54,21 +102,68 @@ export GAMEON_PORT=${GAMEON_PORT-3000}
 export GAMEON_REG_RETRIES=${GAMEON_REG_RETRIES-10}
 export GAMEON_REG_SECONDS_BETWEEN=${GAMEON_REG_SECONDS_BETWEEN-15}
 export GAMEON_TIMESHIFT=${GAMEON_TIMESHIFT-0}
+export GAMEON_AUTOSKEW=${GAMEON_AUTOSKEW-true}
+export GAMEON_SECRET_GRACE=${GAMEON_SECRET_GRACE-300}
+if [ -z "$GAMEON_TLS_CERT" ] ; then
+    export GAMEON_CALLBACK_SCHEME=${GAMEON_CALLBACK_SCHEME-ws}
+else
+    export GAMEON_CALLBACK_SCHEME=${GAMEON_CALLBACK_SCHEME-wss}
+fi
+export GAMEON_TLS_MIN_VERSION=${GAMEON_TLS_MIN_VERSION-1.2}
+export GAMEON_CALLBACK_PATH=${GAMEON_CALLBACK_PATH-/ws}
+if [ -z "$GAMEON_TOKEN_FILE" ] ; then
+    TOKEN_FLAG=""
+else
+    TOKEN_FLAG="-tokenFile $GAMEON_TOKEN_FILE"
+fi
+TLS_FLAGS=""
+if [ -n "$GAMEON_CA_FILE" ] ; then
+    TLS_FLAGS="$TLS_FLAGS -caFile $GAMEON_CA_FILE"
+fi
+if [ -n "$GAMEON_CLIENT_CERT" ] ; then
+    TLS_FLAGS="$TLS_FLAGS -clientCert $GAMEON_CLIENT_CERT -clientKey $GAMEON_CLIENT_KEY"
+fi
+if [ -n "$GAMEON_TLS_CERT" ] ; then
+    TLS_FLAGS="$TLS_FLAGS -tlsCert $GAMEON_TLS_CERT -tlsKey $GAMEON_TLS_KEY -tlsMinVersion $GAMEON_TLS_MIN_VERSION"
+fi
+if [ -n "$GAMEON_PINS" ] ; then
+    TLS_FLAGS="$TLS_FLAGS -pin $GAMEON_PINS"
+fi
+if [ -z "$GAMEON_VERBOSE" ] ; then
+    VERBOSE_FLAG=""
+else
+    VERBOSE_FLAG="-verbose"
+fi
 if [ -z "$GAMEON_DEBUG" ] ; then
     DEBUG_FLAG=""
 else
     DEBUG_FLAG="-d"
 fi
 
-$ROOM_BINARY \
+# exec so that the room, not this script, receives SIGTERM and can
+# shut down gracefully.
+exec $ROOM_BINARY \
   -c $CONTAINER_IP \
   -g $GAMEON_ADDR \
   -cp 3000 \
   -lp $GAMEON_PORT \
   -r $ROOM_NAME \
   -id "$GAMEON_ID" \
-  -secret "$GAMEON_SECRET" \
+  $SECRET_FLAG \
+  -secretGrace $GAMEON_SECRET_GRACE \
   -retries $GAMEON_REG_RETRIES \
   -between $GAMEON_REG_SECONDS_BETWEEN \
   -ts $GAMEON_TIMESHIFT \
+  -autoSkew=$GAMEON_AUTOSKEW \
+  -cs $GAMEON_CALLBACK_SCHEME \
+  -cpath $GAMEON_CALLBACK_PATH \
+  $TOKEN_FLAG \
+  -wsAllow "$GAMEON_WS_ALLOW" \
+  -metricsAllow "$GAMEON_METRICS_ALLOW" \
+  -healthAllow "$GAMEON_HEALTH_ALLOW" \
+  -adminAllow "$GAMEON_ADMIN_ALLOW" \
+  -wsOrigins "$GAMEON_WS_ORIGINS" \
+  $TLS_FLAGS \
+  -redact "$GAMEON_REDACT" \
+  $VERBOSE_FLAG \
   $DEBUG_FLAG
@@ -73,12 +73,16 @@ var (
 	conversation = []*Conversation{&cat, &mouse, &mouse, &cat, &mouse}
 )
 
-func InjectConversations() {
+// Injects random conversations into the room until stop is closed.
+func InjectConversations(stop <-chan struct{}) {
 	locus := "CONVERSATIONS"
 	checkpoint(locus, "BEGIN")
 	for {
 		seconds := rand.Intn(config.maxSecondsBetweenConversations)
-		time.Sleep(time.Duration(seconds) * time.Second)
+		if !pause(time.Duration(seconds)*time.Second, stop) {
+			checkpoint(locus, "STOPPED")
+			return
+		}
 		speaker, segments := findSomethingToSay()
 		if config.debug {
 			checkpoint(locus, "TIME-TO-SPEAK")
@@ -87,12 +91,27 @@ func InjectConversations() {
 			if len(line) > 0 {
 				MakeSmalltalk(line, speaker)
 			}
-			time.Sleep(time.Duration(pauseBetweenSegments) * time.Second)
+			if !pause(time.Duration(pauseBetweenSegments)*time.Second, stop) {
+				checkpoint(locus, "STOPPED")
+				return
+			}
 			speaker = ""
 		}
 	}
 }
 
+// Waits for d to pass. Returns false if stop was closed first.
+func pause(d time.Duration, stop <-chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
 func findSomethingToSay() (speaker string, lines []string) {
 	c := conversation[rand.Intn(len(conversation))]
 	if len(c.unsaid) < 1 {
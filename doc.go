@@ -18,19 +18,93 @@ package main
 //
 // In this discussion we assume that we are consistent in using
 // the same websocket callback every time that our program is
-// started.
+// started. The callback we register is -cs://-c:-cp-cpath, for
+// example ws://10.0.0.1:3000/ws (see callbackTarget).
+//
+// All of our calls to the map service go through the mapclient
+// package (gameon/mapclient), which signs them and retries those
+// that fail for reasons that may pass, waiting longer each time.
 //
 // On starting up, we check to see if our room (by name) has
 // already been registered. If not, then we register it using
-// an authenticated registration POST.  Once we have determined
-// that our room is registered, we use an unauthticated GET to
-// gather the names of all rooms that we currently have registered.
+// an authenticated registration POST. If it has, and its full name,
+// doors or callback differ from our settings, we update it with an
+// authenticated PUT (unless -noupdate is given). If that update fails
+// we carry on with the registration as it is, report the room as
+// degraded, and leave the update to the watchdog. Once we have determined
+// that our room is registered, we use a GET to gather the names
+// of all rooms that we currently have registered.
 // (This code is capable of handling multiple rooms as long as each
-// room was registered using the same callback address.)
+// room was registered using the same callback address.) Every room
+// whose callback is ours, or which is one of our rooms by name but
+// whose callback has drifted, is added to roomRouter (rooms.go), which
+// dispatches each incoming request on the room id it names.
+//
+// Normally we register the single room described by -r and the door
+// flags. Given -manifest, we register every room listed in that file
+// instead, each with its own description and commands (manifest.go),
+// and serve them all from the one /ws listener.
+//
+// Instead of serving, the program can run one of the subcommands
+// list, show, register, update, delete or prune to look at or manage
+// our registrations (cli.go); each prints text or, given -json, JSON.
+// show and delete leave sites that other ids own alone unless given
+// -force.
+// When registration fails, the doctor subcommand checks the usual
+// suspects: the map service, our clock, our id and secret, and our
+// callback (doctor.go).
+//
+// Our calls to the map service carry our signature, so we check its
+// certificate against the system's CAs and -caFile, and against -pin
+// if given, and can present -clientCert (trust.go). -insecure turns
+// the checks off, with a loud warning.
+//
+// Everything we log passes through a redactor (redact.go) that hashes
+// user ids and usernames, masks what players say unless -verbose is
+// given, and strips signatures and tokens; -redact changes its rules.
+//
+// Our shared secret may be given with -secret, in $GAMEON_SECRET or,
+// so that it can be rotated without a restart, in -secretFile, which
+// we poll for changes (secret.go). It is never logged.
+//
+// The map service refuses signed requests whose gameon-date is far
+// from its clock. With -autoSkew we measure its clock from the Date
+// header of each of its responses and correct our timestamps to match
+// (clock.go); -ts is applied on top.
+//
+// Registrations do not stay put: an owner can delete a site in the
+// Game On! UI or change its callback. Every -watchInterval seconds a
+// watchdog (watchdog.go) repeats the check above for each of our
+// rooms, re-registering any that have gone and updating any that have
+// drifted, and refreshes MyRooms and roomRouter. What it found is
+// served from /status/registration and counted in the
+// room_registration_* metrics.
+//
+// Once registered, we fetch each room's exits from the map service,
+// and refresh them every -exitsInterval seconds, so that /go, /look
+// and /doors can name the rooms behind our doors (exits.go).
 //
 // At this point we start our websocket server to listen for
 // service requests from Game On!; the websocket server runs
-// forever until our program is terminated.
+// forever until our program is terminated. Given -tlsCert and
+// -tlsKey it serves TLS itself, reloading the pair when the files
+// change, and we advertise a wss:// callback (certs.go).
+//
+// Verifying new connections
+//
+// Before a websocket is upgraded we check the gameon-date and
+// gameon-signature headers that the mediator sends with its
+// handshake (handshake.go). The signature must be an HMAC of the
+// date made with our shared secret (or with the connection token we
+// registered, if -tokenFile is given, once every room's registration is
+// known to carry it; see token.go), the date must be within
+// -handshakeSkew seconds of our clock, and a handshake may only be
+// used for one connection. Anything else is refused with a 403.
+//
+// The connection must also come from a network in -wsAllow and, if a
+// browser opened it, from an origin in -wsOrigins. /metrics,
+// /health and the admin routes have allowlists of their own
+// (access.go).
 //
 // Ack'ing new connections
 //
@@ -41,28 +115,36 @@ package main
 // ack message is to send the list of procotol versions that this
 // room supports.
 //
+// We support versions 1 and 2. The mediator tells us which one it
+// picked in the first roomHello or roomJoin on the connection and
+// every later player on that connection must use the same one.
+// Version 2 adds roomJoin (a player reconnecting to a room they are
+// already in) and roomPart (a player's connection going away without
+// them leaving). Neither is announced to the rest of the room. What
+// we send back is the same in both versions; the version only decides
+// whether a player whose connection ends has parted or left.
+//
 // Future work:
 //
-// Reconnects to previously-registered rooms are handled
-// somewhat naively: if we find a room that we have previously
-// registered, then we save info about that room so that
-// we can customize later interactions.  However, if those rooms
-// were registered to use a different callback address from the
-// one that we are currently using, then we will never be asked
-// to service those rooms.  A less-simplistic approach would
-// save only those rooms that had a callback address matching
-// the callback address that we are currently registering;
-// a more ambitious approach would be to start another go routine
-// to handle each additional callback address that we discover
-// in our list of previously-registered rooms.
+// Rooms that we previously registered with a different callback
+// address are remembered in MyRooms but are not served, since we
+// will never be asked to service them. A more ambitious approach
+// would be to start another go routine to handle each additional
+// callback address that we discover in our list of
+// previously-registered rooms.
 
 // Game On!
 // Main site: https://gameontext.org
 
 // JSON Marshalling Notes
 //
-// 1. All marshalling is performed using json.MarshalIndent so
-//    that any JSON we log is formatted nicely.
+// 1. Messages exchanged with the mediator are framed, marshalled
+//    and validated by the gameon/protocol package, which has a Go
+//    type for every kind of message. Handlers build responses with
+//    its constructors (protocol.NewEvent, protocol.NewLocation, ...)
+//    and hand them to SendMessage. Other marshalling is performed
+//    using json.MarshalIndent so that any JSON we log is formatted
+//    nicely.
 //
 // 2. The additional tagging that we use to annotate our Go
 //    struct types is explained in the Go json documentation, which
@@ -72,16 +154,22 @@ package main
 //
 // 3. Some JSON responses have more information than we
 //    need, so we only define marshalling for the fields we
-//    care about. See RoomRegistrationResp for an example
-//    of a struct designed to keep a subset of the information
-//    returned in a response. (We do not need exits or coords
-//    so we do not map those fields.)
+//    care about. See mapclient.Site (gameon/mapclient) for an
+//    example of a struct designed to keep a subset of the
+//    information returned in a response. (We map exits, which tell
+//    us our neighbours, and coords, but not the other fields.)
 
 // Room commands
 //
 // The handling for each room command (/go, /look, etc.) is kept in
 // its own source file and it is typically named room<cmd>.go, as in
-// roomchat.go, roomlook.go, etc.
+// roomchat.go, roomlook.go, etc. Commands must not sleep; responses
+// that are spoken with pauses are handed to ScheduleTimedText.
+//
+// Every chat and command first passes through admitRequest
+// (ratelimit.go), which holds each player to a per-class rate and
+// may mute a player who floods the room. Add a command to
+// expensiveCommands if answering it is costly.
 
 // Chat (broadcast messages)
 //
@@ -93,8 +181,22 @@ package main
 // isolate shared data structures. BroadcastMessage(r, m, sender,
 // receiver string) should be used to send broadcast messages.
 // Messages to a player who is still in the room should be sent
-// more directly using SendMessageToPlayer(conn *websocket.Conn,
+// more directly using SendMessageToPlayer(sess *Session,
 // mUser, uid string).
+//
+// Sessions
+//
+// Each websocket connection is owned by a Session (session.go).
+// Nothing but the session's writer goroutine may write to the
+// connection; everything else queues messages with Session.Send.
+// The -sendQueue and -slowConsumer flags control how much may be
+// queued and what happens when a connection cannot keep up.
+//
+// Messages larger than -maxMessage end the connection. Binary frames
+// and messages that the protocol package cannot decode, or that are
+// missing a required field, are refused; the player is told why if
+// their user id can be found, and after -maxMalformed of them the
+// connection is closed with a policy-violation frame.
 
 // Deleting rooms
 //
@@ -103,3 +205,9 @@ package main
 // time a console command has been added to delete a room. This
 // room's delete code will remain, however, as an example of
 // deleting a room from outside of Game On!
+
+// Testing
+//
+// The gameon/gameontest package fakes the map service and the
+// mediator, so the end-to-end tests (e2e_test.go) register, repair
+// and play in a room without reaching gameontext.org.
@@ -0,0 +1,284 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// The doctor subcommand
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/url"
+	"sample-room-golang/gameon/mapclient"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Registration usually fails for one of a few reasons: the map
+// service cannot be reached, our clock is too far from its clock (see
+// -ts), our id and secret do not match, or Game On! cannot reach our
+// callback. The doctor subcommand checks each of these in turn, and
+// prints exactly how we would sign a registration so that it can be
+// compared with what the map service expects.
+
+// The outcome of one of doctor's checks.
+const (
+	diagOK      = "ok"
+	diagWarning = "warning"
+	diagFailed  = "failed"
+)
+
+// A diagnosis is the result of one check.
+type diagnosis struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// How we would sign a registration.
+type signingSample struct {
+	Request   string            `json:"request"`
+	Canonical string            `json:"canonical"`
+	Signature string            `json:"signature"`
+	Headers   map[string]string `json:"headers"`
+}
+
+type doctorReport struct {
+	Checks  []diagnosis    `json:"checks"`
+	Signing *signingSample `json:"signing,omitempty"`
+}
+
+func (d *doctorReport) note(check, status, format string, args ...interface{}) {
+	d.Checks = append(d.Checks, diagnosis{check, status, fmt.Sprintf(format, args...)})
+}
+
+// doctor [-json]
+func doctor(ctx context.Context, mc *mapclient.Client, inv *invocation) error {
+	var d doctorReport
+	date, at := d.checkMapService(ctx, mc)
+	d.checkClock(date, at)
+	d.checkCredentials(ctx, mc)
+	d.checkCallbackAddress()
+	d.checkWebsocket(mc)
+	d.Signing = signRegistration(mc)
+
+	if inv.json {
+		if err := inv.printJSON(&d); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(inv.out, 0, 4, 2, ' ', 0)
+		for _, c := range d.Checks {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Status, c.Check, c.Detail)
+		}
+		if s := d.Signing; s != nil {
+			fmt.Fprintf(w, "\nA registration would be signed as follows.\n")
+			fmt.Fprintf(w, "request:\t%s\n", s.Request)
+			fmt.Fprintf(w, "canonical string:\t%s\n", s.Canonical)
+			for _, k := range signingHeaders {
+				fmt.Fprintf(w, "%s:\t%s\n", k, s.Headers[k])
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	for _, c := range d.Checks {
+		if c.Status == diagFailed {
+			return RegError{fmt.Sprintf("doctor: the %s check failed.", c.Check)}
+		}
+	}
+	return nil
+}
+
+// The headers with which requests to the map service are signed.
+var signingHeaders = []string{"gameon-id", "gameon-date", "gameon-sig-body", "gameon-signature"}
+
+// Checks that the map service answers. Returns the Date header of its
+// answer, if any, and the time at which it was most likely written.
+func (d *doctorReport) checkMapService(ctx context.Context, mc *mapclient.Client) (date string, at time.Time) {
+	const check = "map service"
+	u := mc.BaseURL + "/sites?owner=" + url.QueryEscape(config.id)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		d.note(check, diagFailed, "%s", err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, mc.Timeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := mc.HTTP.Do(req.WithContext(ctx))
+	if err != nil {
+		d.note(check, diagFailed, "Cannot reach %s: %s", mc.BaseURL, err.Error())
+		return
+	}
+	resp.Body.Close()
+	elapsed := time.Since(start)
+	at = start.Add(elapsed / 2)
+	date = resp.Header.Get("Date")
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		d.note(check, diagWarning, "%s answered %s", mc.BaseURL, resp.Status)
+		return
+	}
+	d.note(check, diagOK, "%s answered in %v", mc.BaseURL, elapsed.Round(time.Millisecond))
+	return
+}
+
+// Compares the map service's clock, as given by date, with ours,
+// including any -ts shift but not the correction that -autoSkew
+// makes.
+func (d *doctorReport) checkClock(date string, at time.Time) {
+	const check = "clock"
+	if at.IsZero() {
+		d.note(check, diagWarning, "Not checked, since the map service did not answer.")
+		return
+	}
+	theirs, err := http.ParseTime(date)
+	if err != nil {
+		d.note(check, diagWarning, "The map service sent no usable Date header ('%s').", date)
+		return
+	}
+	ours := at.Add(time.Duration(config.timeShift) * time.Millisecond)
+	skew := ours.Sub(theirs)
+	// The Date header only has whole seconds.
+	if skew > -2*time.Second && skew < 2*time.Second {
+		d.note(check, diagOK, "Our timestamps are within a second or two of the map service's clock.")
+		return
+	}
+	if config.autoSkew {
+		d.note(check, diagOK, "Our clock is %v off the map service's clock, which -autoSkew corrects for.",
+			skew.Round(time.Second))
+		return
+	}
+	status := diagWarning
+	if skew < -time.Minute || skew > time.Minute {
+		status = diagFailed
+	}
+	d.note(check, status, "Our timestamps are %v off the map service's clock; try -ts %d.",
+		skew.Round(time.Second), config.timeShift-int(skew/time.Millisecond))
+}
+
+// Makes a signed request that changes nothing, the deletion of a
+// site that does not exist, to find out whether the map service
+// accepts our id and secret.
+func (d *doctorReport) checkCredentials(ctx context.Context, mc *mapclient.Client) {
+	const check = "credentials"
+	raw := make([]byte, 8)
+	rand.Read(raw)
+	err := mc.Delete(ctx, "doctor-"+hex.EncodeToString(raw))
+	switch {
+	case mapclient.IsNotFound(err), mapclient.IsForbidden(err):
+		d.note(check, diagOK, "The map service accepted the signature of %s.", config.id)
+	case mapclient.IsUnauthorized(err):
+		d.note(check, diagFailed, "The map service refused the signature of %s: check -id and -secret, and the clock.",
+			config.id)
+	case err == nil:
+		d.note(check, diagWarning, "The map service claims to have deleted a site that should not exist.")
+	default:
+		d.note(check, diagWarning, "Could not check: %s", err.Error())
+	}
+}
+
+// Checks that our callback address resolves to something that Game
+// On! could reach.
+func (d *doctorReport) checkCallbackAddress() {
+	const check = "callback address"
+	addrs, err := net.LookupHost(config.callbackAddr)
+	if err != nil {
+		d.note(check, diagFailed, "%s does not resolve: %s", config.callbackAddr, err.Error())
+		return
+	}
+	for _, a := range addrs {
+		if ip := net.ParseIP(a); ip != nil && ip.IsLoopback() {
+			d.note(check, diagWarning, "%s resolves to %s, which Game On! cannot reach.", config.callbackAddr, a)
+			return
+		}
+	}
+	d.note(check, diagOK, "%s resolves to %s", config.callbackAddr, strings.Join(addrs, ", "))
+}
+
+// Serves our websocket, unless something already listens on our port,
+// and dials it at our callback with a signed handshake, as Game On!
+// would.
+func (d *doctorReport) checkWebsocket(mc *mapclient.Client) {
+	const check = "websocket"
+	target := callbackTarget()
+	via := "our own listener"
+	ln, err := net.Listen("tcp", port())
+	if err == nil {
+		if config.serveTLS != nil {
+			ln = tls.NewListener(ln, config.serveTLS)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/ws", roomHandler)
+		if config.callbackPath != "/ws" {
+			mux.HandleFunc(config.callbackPath, roomHandler)
+		}
+		srv := &http.Server{Handler: mux}
+		go srv.Serve(ln)
+		defer srv.Close()
+	} else {
+		via = fmt.Sprintf("whatever already listens on %s", port())
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: mc.Timeout}
+	if tr, ok := mc.HTTP.Transport.(*http.Transport); ok && tr.TLSClientConfig != nil {
+		// Trust what we trust for the map service, but our pins are
+		// for the map service, not for our own callback.
+		dialer.TLSClientConfig = tr.TLSClientConfig.Clone()
+		dialer.TLSClientConfig.VerifyPeerCertificate = nil
+	}
+	date := time.Now().UTC().Format(http.TimeFormat)
+	h := http.Header{
+		"gameon-date":      {date},
+		"gameon-signature": {mapclient.HMAC(handshakeKey(), date)},
+	}
+	conn, resp, err := dialer.Dial(target, h)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%s (%s)", err.Error(), resp.Status)
+		}
+		d.note(check, diagFailed, "Cannot open %s, served by %s: %s", target, via, err.Error())
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(mc.Timeout))
+	_, b, err := conn.ReadMessage()
+	if err != nil || !strings.HasPrefix(string(b), "ack,") {
+		d.note(check, diagWarning, "Opened %s, served by %s, but got no ack.", target, via)
+		return
+	}
+	d.note(check, diagOK, "Opened %s, served by %s, and got %s", target, via, b)
+}
+
+// Signs a registration of our first room as addAuthenticationHeaders
+// would, and returns what was signed and the result.
+func signRegistration(mc *mapclient.Client) *signingSample {
+	if len(roomSpecs) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(genRegistration(roomSpecs[0]))
+	if err != nil {
+		return nil
+	}
+	req, err := http.NewRequest("POST", mc.BaseURL+"/sites", bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	addAuthenticationHeaders(req, string(body))
+	s := signingSample{Request: "POST " + req.URL.String(), Headers: make(map[string]string)}
+	for _, k := range signingHeaders {
+		s.Headers[k] = req.Header.Get(k)
+	}
+	s.Canonical = s.Headers["gameon-id"] + s.Headers["gameon-date"] + s.Headers["gameon-sig-body"]
+	s.Signature = s.Headers["gameon-signature"]
+	return &s
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"sample-room-golang/gameon/gameontest"
+	"strconv"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	ms := gameontest.NewMapService()
+	defer ms.Close()
+	useTestConfig(ms)
+	roomSpecs = []*roomSpec{{Name: "DOCTOR.ROOM", FullName: "The Surgery"}}
+	defer func() { roomSpecs = nil }()
+
+	// Find a free port for doctor to serve the websocket on.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.callbackPort = ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	os.Setenv("PORT", strconv.Itoa(config.callbackPort))
+	defer os.Unsetenv("PORT")
+	defer func() { config.callbackPort = 3000 }()
+
+	run := func() (doctorReport, error) {
+		var out bytes.Buffer
+		inv := &invocation{cmd: findSubcommand("doctor"), json: true, out: &out}
+		err := doctor(context.Background(), newMapClient(http.DefaultClient), inv)
+		var d doctorReport
+		if jerr := json.Unmarshal(out.Bytes(), &d); jerr != nil {
+			t.Fatalf("doctor -json printed %q: %v", out.String(), jerr)
+		}
+		return d, err
+	}
+	status := func(d doctorReport, check string) string {
+		for _, c := range d.Checks {
+			if c.Check == check {
+				return c.Status
+			}
+		}
+		return "missing"
+	}
+
+	d, err := run()
+	if err != nil {
+		t.Errorf("doctor failed: %v\n%+v", err, d.Checks)
+	}
+	for _, check := range []string{"map service", "clock", "credentials", "websocket"} {
+		if s := status(d, check); s != diagOK {
+			t.Errorf("The %s check is %s: %+v", check, s, d.Checks)
+		}
+	}
+	if d.Signing == nil || d.Signing.Headers["gameon-id"] != config.id || len(d.Signing.Signature) == 0 {
+		t.Errorf("Unexpected signing sample %+v", d.Signing)
+	}
+
+	config.secret = "not-the-secret"
+	defer func() { config.secret = "e2e-secret" }()
+	d, err = run()
+	if err == nil || status(d, "credentials") != diagFailed {
+		t.Errorf("doctor did not notice a wrong secret: %+v", d.Checks)
+	}
+}
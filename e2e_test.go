@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sample-room-golang/gameon/gameontest"
+	"sample-room-golang/gameon/mapclient"
+	"sample-room-golang/gameon/protocol"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// End-to-end tests against the fakes in gameon/gameontest.
+
+var startTracker sync.Once
+
+// Sets config as the flags' defaults would, for a room that registers
+// with ms and serves players itself.
+func useTestConfig(ms *gameontest.MapService) {
+	config.gameonAddr = ms.Addr()
+	config.protocol = "http"
+	config.id = "e2e-owner"
+	config.secret = "e2e-secret"
+	config.retries = 1
+	config.mapTimeout = 5
+	config.callbackScheme = "ws"
+	config.callbackAddr = "127.0.0.1"
+	config.callbackPort = 3000
+	config.callbackPath = "/ws"
+	config.handshakeSkew = 300
+	config.sendQueueSize = 64
+	config.slowConsumer = SlowConsumerDrop
+	config.pongWait = 60
+	config.writeWait = 10
+	config.maxMessageSize = 8192
+	config.maxMalformed = 10
+	ms.AddUser(config.id, config.secret)
+	startTracker.Do(func() { go TrackPlayers() })
+}
+
+// Returns whether registrationState is healthy, and what it says
+// about the room with the given name.
+func registrationOf(name string) (bool, siteState) {
+	r := registrationState.report()
+	for _, s := range r.Sites {
+		if s.Name == name {
+			return r.Healthy, s
+		}
+	}
+	return r.Healthy, siteState{}
+}
+
+// Stops serving every room.
+func forgetRooms() {
+	for _, r := range roomRouter.Rooms() {
+		roomRouter.Remove(r.id)
+	}
+}
+
+func TestRegistrationEndToEnd(t *testing.T) {
+	ms := gameontest.NewMapService()
+	defer ms.Close()
+	useTestConfig(ms)
+	defer forgetRooms()
+	roomSpecs = []*roomSpec{{Name: "E2E.ROOM", FullName: "The Test Room", Doors: doorSpec{North: "A red door."}}}
+	defer func() { roomSpecs = nil }()
+	mc := newMapClient(http.DefaultClient)
+	ctx := context.Background()
+
+	if err := registerWithRetries(mc); err != nil {
+		t.Fatalf("Registration failed: %v", err)
+	}
+	sites := ms.Sites()
+	if len(sites) != 1 {
+		t.Fatalf("Expected one site, got %+v", sites)
+	}
+	site := sites[0]
+	if site.Info.ConnectionDetails.Target != callbackTarget() || site.Info.Doors.South != "A red door." {
+		t.Errorf("Registered %+v", site.Info)
+	}
+	if roomRouter.Lookup(site.Id) == nil {
+		t.Errorf("The registered room is not served.")
+	}
+
+	// The owner deletes the site in the Game On! UI.
+	ms.DeleteSite(site.Id)
+	checkRegistrations(ctx, mc)
+	sites = ms.Sites()
+	if len(sites) != 1 || sites[0].Id == site.Id {
+		t.Fatalf("The deleted site was not registered again: %+v", sites)
+	}
+	if roomRouter.Lookup(site.Id) != nil || roomRouter.Lookup(sites[0].Id) == nil {
+		t.Errorf("Serving %v rather than the new site %s", roomRouter.Rooms(), sites[0].Id)
+	}
+	site = sites[0]
+
+	// The owner points the site somewhere else.
+	ms.EditSite(site.Id, func(info *mapclient.RoomInfo) {
+		info.ConnectionDetails.Target = "ws://elsewhere.example.org/ws"
+	})
+	checkRegistrations(ctx, mc)
+	if s := ms.Site(site.Id); s.Info.ConnectionDetails.Target != callbackTarget() {
+		t.Errorf("The drifted site was not updated: %+v", s.Info.ConnectionDetails)
+	}
+	if healthy, s := registrationOf("E2E.ROOM"); !healthy || s.State != RegUpdated {
+		t.Errorf("Unexpected status %v %+v", healthy, s)
+	}
+}
+
+func TestGameplayEndToEnd(t *testing.T) {
+	ms := gameontest.NewMapService()
+	defer ms.Close()
+	useTestConfig(ms)
+	defer forgetRooms()
+	roomRouter.Add(newRoom("e2e.play", "E2E.PLAY", "The Play Room"))
+	neighbours.set("e2e.play", map[string]*mapclient.Exit{"n": {Name: "NORTH", FullName: "The North Room"}})
+
+	srv := httptest.NewServer(http.HandlerFunc(roomHandler))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	if _, err := gameontest.Dial(url, "not-the-secret"); err == nil {
+		t.Errorf("A handshake signed with the wrong secret was accepted.")
+	}
+	m, err := gameontest.Dial(url, config.secret)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer m.Close()
+	if len(m.Versions) != 2 {
+		t.Errorf("The room acked versions %v", m.Versions)
+	}
+
+	const uid, name = "dummy.E2E", "E2E"
+	step := func(what string, err error) {
+		if err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+	}
+	step("hello", m.Hello("e2e.play", uid, name, 2))
+	_, err = m.ExpectEvent(uid, "Welcome to The Play Room")
+	step("welcome", err)
+	loc, err := m.ExpectLocation(uid)
+	step("location", err)
+	if loc.FullName != "The Play Room" || loc.Commands["/doors"] == "" {
+		t.Errorf("Unexpected location %+v", loc)
+	}
+
+	step("/wink", m.Say("e2e.play", uid, name, "/wink"))
+	_, err = m.ExpectEvent(uid, "winks at you")
+	step("wink", err)
+
+	step("/doors", m.Say("e2e.play", uid, name, "/doors"))
+	_, err = m.ExpectEvent(uid, "To the north is The North Room.")
+	step("doors", err)
+
+	step("chat", m.Say("e2e.play", uid, name, "Is anybody there?"))
+	_, err = m.Expect("*", func(msg protocol.Message) bool {
+		c, ok := msg.(*protocol.Chat)
+		return ok && c.Username == name && c.Content == "Is anybody there?"
+	})
+	step("chat", err)
+
+	step("/go", m.Say("e2e.play", uid, name, "/go north"))
+	_, err = m.ExpectEvent(uid, "The North Room")
+	step("go", err)
+	x, err := m.ExpectExit(uid)
+	step("exit", err)
+	if x.ExitId != "n" {
+		t.Errorf("Left through %s, not n", x.ExitId)
+	}
+	step("goodbye", m.Goodbye("e2e.play", uid, name))
+}
+
+func TestClockSkewEndToEnd(t *testing.T) {
+	ms := gameontest.NewMapService()
+	defer ms.Close()
+	useTestConfig(ms)
+	defer forgetRooms()
+	roomSpecs = []*roomSpec{{Name: "SKEW.ROOM", FullName: "The Late Room"}}
+	defer func() { roomSpecs = nil }()
+	ms.Offset = 10 * time.Minute
+	config.autoSkew = true
+	defer func() {
+		config.autoSkew = false
+		clockSkew.mu.Lock()
+		clockSkew.offset, clockSkew.measured = 0, false
+		clockSkew.mu.Unlock()
+	}()
+
+	if err := registerWithRetries(newMapClient(http.DefaultClient)); err != nil {
+		t.Fatalf("Registration ten minutes behind the map service failed: %v", err)
+	}
+	if len(ms.Sites()) != 1 {
+		t.Errorf("Expected one site, got %+v", ms.Sites())
+	}
+	if d := clockSkew.get() - ms.Offset; d < -2*time.Second || d > 2*time.Second {
+		t.Errorf("Measured a skew of %v rather than %v", clockSkew.get(), ms.Offset)
+	}
+}
+
+func TestTokenEndToEnd(t *testing.T) {
+	ms := gameontest.NewMapService()
+	defer ms.Close()
+	ms.HideTokens = true
+	useTestConfig(ms)
+	defer forgetRooms()
+	roomSpecs = []*roomSpec{{Name: "TOKEN.ROOM", FullName: "The Locked Room"}}
+	defer func() { roomSpecs = nil }()
+	defer func() {
+		config.token = ""
+		registeredTokensMu.Lock()
+		registeredTokens = make(map[string]registeredToken)
+		registeredTokensMu.Unlock()
+	}()
+	mc := newMapClient(http.DefaultClient)
+	forgetHandshakes()
+	handshake := func(key string) error {
+		date := time.Now().UTC().Format(time.RFC1123)
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Header.Set("gameon-date", date)
+		req.Header.Set("gameon-signature", buildHmac([]string{date}, key))
+		return verifyHandshake(req)
+	}
+
+	if err := registerWithRetries(mc); err != nil {
+		t.Fatalf("Registration without a token failed: %v", err)
+	}
+
+	// We restart with a token, which is not registered yet, so the
+	// mediator still signs with the secret.
+	config.token = "e2e-token"
+	if err := handshake(config.secret); err != nil {
+		t.Errorf("Before the token was registered a handshake signed with the secret was rejected: %v", err)
+	}
+	if err := registerWithRetries(mc); err != nil {
+		t.Fatalf("Registration with a token failed: %v", err)
+	}
+	site := ms.Sites()[0]
+	if site.Info.ConnectionDetails.Token != config.token {
+		t.Fatalf("The token was not registered: %+v", site.Info.ConnectionDetails)
+	}
+	if err := handshake(config.secret); err == nil {
+		t.Errorf("After the token was registered a handshake signed with the secret was accepted.")
+	}
+	if err := handshake(config.token); err != nil {
+		t.Errorf("A handshake signed with our token was rejected: %v", err)
+	}
+
+	// The map service does not show us the token, but we know that
+	// we registered it and do not send it again.
+	checkRegistrations(context.Background(), mc)
+	if s := ms.Site(site.Id); s.Rev != site.Rev {
+		t.Errorf("The registration was updated again, to %s", s.Rev)
+	}
+}
+
+func TestRegistrationDriftEndToEnd(t *testing.T) {
+	ms := gameontest.NewMapService()
+	defer ms.Close()
+	var mu sync.Mutex
+	failUpdates := false
+	ms.Fail = func(r *http.Request) int {
+		mu.Lock()
+		defer mu.Unlock()
+		if failUpdates && r.Method == "PUT" {
+			return http.StatusInternalServerError
+		}
+		return 0
+	}
+	useTestConfig(ms)
+	defer forgetRooms()
+	roomSpecs = []*roomSpec{{Name: "DRIFT.ROOM", FullName: "The Wandering Room"}}
+	defer func() { roomSpecs = nil }()
+	config.noUpdate = true
+	defer func() { config.noUpdate = false }()
+	mc := newMapClient(http.DefaultClient)
+	ctx := context.Background()
+
+	// Registered by an earlier version of the room, with no path.
+	id := ms.AddSite(config.id, mapclient.RoomInfo{Name: "DRIFT.ROOM", FullName: "The Wandering Room",
+		ConnectionDetails: &mapclient.ConnectionDetails{Type: "websocket", Target: "WS://127.0.0.1:3000"}})
+	if err := registerWithRetries(mc); err != nil {
+		t.Fatalf("Registration failed: %v", err)
+	}
+	if roomRouter.Lookup(id) == nil {
+		t.Errorf("The site registered without a path is not served.")
+	}
+
+	// Its owner points it elsewhere; with -noupdate we serve it anyway.
+	ms.EditSite(id, func(info *mapclient.RoomInfo) {
+		info.ConnectionDetails.Target = "ws://elsewhere.example.org/ws"
+	})
+	if err := rememberMyRooms(ctx, mc); err != nil || roomRouter.Lookup(id) == nil {
+		t.Errorf("The drifted site is not served: %v", err)
+	}
+
+	// The update fails: we keep serving the site and report it.
+	config.noUpdate = false
+	mu.Lock()
+	failUpdates = true
+	mu.Unlock()
+	if err := registerWithRetries(mc); err != nil {
+		t.Fatalf("A failed update stopped the room: %v", err)
+	}
+	if healthy, s := registrationOf("DRIFT.ROOM"); healthy || s.State != RegDegraded || s.Id != id {
+		t.Errorf("Unexpected status %v %+v", healthy, s)
+	}
+	if roomRouter.Lookup(id) == nil {
+		t.Errorf("The site whose update failed is not served.")
+	}
+
+	// The watchdog tries again.
+	mu.Lock()
+	failUpdates = false
+	mu.Unlock()
+	checkRegistrations(ctx, mc)
+	if s := ms.Site(id); s.Info.ConnectionDetails.Target != callbackTarget() {
+		t.Errorf("The watchdog did not update the site: %+v", s.Info.ConnectionDetails)
+	}
+	if healthy, s := registrationOf("DRIFT.ROOM"); !healthy || s.State != RegUpdated {
+		t.Errorf("Unexpected status %v %+v", healthy, s)
+	}
+}
@@ -46,3 +46,18 @@ type VersionError struct {
 }
 
 func (e VersionError) Error() string { return fmt.Sprintf("VERSION.ERROR: %s", e.message) }
+
+// HandshakeError describes a websocket upgrade request that did not carry
+// a valid Game On! signature.
+type HandshakeError struct {
+	message string
+}
+
+func (e HandshakeError) Error() string { return fmt.Sprintf("HANDSHAKE.ERROR: %s", e.message) }
+
+// SessionError describes a message that could not be queued for delivery.
+type SessionError struct {
+	message string
+}
+
+func (e SessionError) Error() string { return fmt.Sprintf("SESSION.ERROR: %s", e.message) }
@@ -0,0 +1,156 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Neighbouring rooms
+package main
+
+import (
+	"context"
+	"fmt"
+	"sample-room-golang/gameon/mapclient"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The map service, not the room, decides where a room goes on the
+// map and so which rooms are behind its doors. It reports them as the
+// exits of the room's site. We fetch the exits of every room we serve
+// at startup and again every -exitsInterval seconds, so that /go,
+// /look and /doors can say where each door actually leads. Until the
+// exits are known, or if fetching them fails, those commands make do
+// without.
+
+// The directions that Game On! lets players go, in the order in which
+// we describe them.
+var directions = []struct {
+	id   string
+	name string
+}{
+	{"n", "north"},
+	{"s", "south"},
+	{"e", "east"},
+	{"w", "west"},
+}
+
+// Returns the direction id ("n") for a direction given by id or name
+// ("n" or "North"), or "" if there is no such direction.
+func directionId(dir string) string {
+	dir = strings.ToLower(strings.TrimSpace(dir))
+	for _, d := range directions {
+		if dir == d.id || dir == d.name {
+			return d.id
+		}
+	}
+	return ""
+}
+
+// Returns the name ("north") of the direction with the given id.
+func directionName(id string) string {
+	for _, d := range directions {
+		if id == d.id {
+			return d.name
+		}
+	}
+	return id
+}
+
+// An ExitCache holds the exits of the rooms we serve, by room id.
+type ExitCache struct {
+	mu    sync.RWMutex
+	exits map[string]map[string]*mapclient.Exit
+}
+
+var neighbours = ExitCache{exits: make(map[string]map[string]*mapclient.Exit)}
+
+// Replaces the exits of the room with the given id.
+func (c *ExitCache) set(roomId string, exits map[string]*mapclient.Exit) {
+	c.mu.Lock()
+	c.exits[roomId] = exits
+	c.mu.Unlock()
+}
+
+// Forgets the exits of rooms that we no longer serve.
+func (c *ExitCache) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.exits {
+		if roomRouter.Lookup(id) == nil {
+			delete(c.exits, id)
+		}
+	}
+}
+
+// Returns the exit in direction dir (an id such as "n") from the room
+// with the given id, or nil if we do not know it.
+func (c *ExitCache) lookup(roomId, dir string) *mapclient.Exit {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.exits[roomId][dir]
+}
+
+// Returns a sentence describing each known exit from the room with the
+// given id, in the order of directions.
+func (c *ExitCache) describe(roomId string) []string {
+	var lines []string
+	for _, d := range directions {
+		if x := c.lookup(roomId, d.id); x != nil {
+			lines = append(lines, describeExit(d.id, x))
+		}
+	}
+	return lines
+}
+
+// Returns the name by which players know the room that x leads to.
+func exitName(x *mapclient.Exit) string {
+	if len(x.FullName) > 0 {
+		return x.FullName
+	}
+	return x.Name
+}
+
+// Describes the exit in direction dir, for example "To the north is
+// The Dusty Library. A frost-covered door leads to the south."
+func describeExit(dir string, x *mapclient.Exit) string {
+	s := fmt.Sprintf("To the %s is %s.", directionName(dir), exitName(x))
+	if len(x.Door) > 0 {
+		s += " " + x.Door
+	}
+	return s
+}
+
+// Fetches the exits of every room we serve. A room whose site cannot
+// be fetched keeps the exits we knew before.
+func refreshExits(ctx context.Context, mc *mapclient.Client) {
+	locus := "EXITS.REFRESH"
+	for _, r := range roomRouter.Rooms() {
+		site, err := mc.Get(ctx, r.id)
+		if err != nil {
+			checkpoint(locus, fmt.Sprintf("FAILED %s err=%s", r.id, err.Error()))
+			continue
+		}
+		neighbours.set(r.id, site.Exits)
+		if config.debug {
+			for _, line := range neighbours.describe(r.id) {
+				checkpoint(locus, fmt.Sprintf("%s %s", r.id, line))
+			}
+		}
+	}
+	neighbours.prune()
+}
+
+// Fetches the exits of the rooms we serve now and then every
+// config.exitsInterval seconds until stop is closed.
+func RefreshExits(mc *mapclient.Client, stop <-chan struct{}) {
+	locus := "EXITS"
+	checkpoint(locus, "BEGIN")
+	refreshExits(context.Background(), mc)
+	if config.exitsInterval == 0 {
+		return
+	}
+	for pause(time.Duration(config.exitsInterval)*time.Second, stop) {
+		refreshExits(context.Background(), mc)
+	}
+	checkpoint(locus, "STOPPED")
+}
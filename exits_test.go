@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sample-room-golang/gameon/mapclient"
+	"testing"
+)
+
+func TestExitCache(t *testing.T) {
+	roomRouter.Add(newRoom("exits.test", "EXITS", "The Exits Test"))
+	defer roomRouter.Remove("exits.test")
+
+	if lines := neighbours.describe("exits.test"); len(lines) != 0 {
+		t.Errorf("Unknown exits were described: %v", lines)
+	}
+	neighbours.set("exits.test", map[string]*mapclient.Exit{
+		"w": {Name: "ROOM.W", Door: "A red door."},
+		"n": {Name: "ROOM.N", FullName: "The North Room"},
+		"u": {Name: "ROOM.U"},
+	})
+	lines := neighbours.describe("exits.test")
+	expected := []string{
+		"To the north is The North Room.",
+		"To the west is ROOM.W. A red door.",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], lines[i])
+		}
+	}
+	if x := neighbours.lookup("exits.test", directionId(" West")); x == nil || x.Name != "ROOM.W" {
+		t.Errorf("West leads to %+v", x)
+	}
+
+	roomRouter.Remove("exits.test")
+	neighbours.prune()
+	if x := neighbours.lookup("exits.test", "n"); x != nil {
+		t.Errorf("The exits of a room we no longer serve were kept.")
+	}
+}
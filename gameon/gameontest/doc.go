@@ -0,0 +1,25 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Package gameontest provides fakes of the parts of Game On! that a
+// room talks to, so that registration and gameplay can be tested
+// without a network.
+//
+// MapService is an in-process map service that serves /map/v1/sites.
+// It checks the signature on every request that changes a site, just
+// as Game On! does, and lets a test play the owner deleting or
+// editing a site behind the room's back:
+//
+//	ms := gameontest.NewMapService()
+//	defer ms.Close()
+//	ms.AddUser("my-id", "my-secret")
+//	mc := mapclient.New(http.DefaultClient, ms.URL(), "my-id", "my-secret")
+//
+// Mediator plays the Game On! mediator: it dials a room's websocket
+// with a signed handshake and sends and receives frames:
+//
+//	m, err := gameontest.Dial("ws://127.0.0.1:3000/ws", "my-secret")
+//	m.Hello(roomId, "dummy.DevUser", "DevUser", 2)
+//	ev, err := m.ExpectEvent("dummy.DevUser", "Welcome")
+package gameontest
@@ -0,0 +1,403 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+package gameontest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sample-room-golang/gameon/mapclient"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sitesPath = "/map/v1/sites"
+
+// A MapService is a fake Game On! map service.
+type MapService struct {
+	server *httptest.Server
+	// Skew is how far the gameon-date of a signed request may be from
+	// our clock. NewMapService sets it to five minutes.
+	Skew time.Duration
+	// Offset is how far our clock is ahead of the real one, as sent
+	// in the Date header and used to check gameon-date.
+	Offset time.Duration
+	// HideTokens leaves connection tokens out of the sites we serve,
+	// as the real map service may. Sites and Site still show them.
+	HideTokens bool
+	// Fail, if set, is called with each request and, if it returns a
+	// status other than zero, the request fails with that status.
+	Fail func(r *http.Request) int
+
+	mu      sync.Mutex
+	secrets map[string]string
+	sites   map[string]*mapclient.Site
+	// Site ids in the order in which the sites were created.
+	order  []string
+	nextId int
+	calls  int
+}
+
+// NewMapService starts a map service with no users and no sites.
+// Close it when done.
+func NewMapService() *MapService {
+	ms := &MapService{
+		Skew:    5 * time.Minute,
+		secrets: make(map[string]string),
+		sites:   make(map[string]*mapclient.Site),
+	}
+	ms.server = httptest.NewServer(ms)
+	return ms
+}
+
+// Close shuts the map service down.
+func (ms *MapService) Close() { ms.server.Close() }
+
+// URL returns the base URL of the map service, ending in /map/v1, as
+// mapclient.New wants it.
+func (ms *MapService) URL() string { return ms.server.URL + "/map/v1" }
+
+// Addr returns the host and port of the map service.
+func (ms *MapService) Addr() string { return strings.TrimPrefix(ms.server.URL, "http://") }
+
+// AddUser lets id sign requests with secret.
+func (ms *MapService) AddUser(id, secret string) {
+	ms.mu.Lock()
+	ms.secrets[id] = secret
+	ms.mu.Unlock()
+}
+
+// Calls returns the number of requests served so far.
+func (ms *MapService) Calls() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.calls
+}
+
+// AddSite registers a site for owner without any checks, as if it
+// had been registered earlier, and returns its id. Unlike a POST it
+// happily adds a second site with the same name.
+func (ms *MapService) AddSite(owner string, info mapclient.RoomInfo) string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.add(owner, &info).Id
+}
+
+// Sites returns a copy of every site, in the order they were created.
+func (ms *MapService) Sites() []mapclient.Site {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var all []mapclient.Site
+	for _, id := range ms.order {
+		all = append(all, copySite(ms.sites[id]))
+	}
+	return all
+}
+
+// Site returns a copy of the site with the given id, or nil.
+func (ms *MapService) Site(id string) *mapclient.Site {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	s := ms.sites[id]
+	if s == nil {
+		return nil
+	}
+	c := copySite(s)
+	return &c
+}
+
+// DeleteSite deletes a site, as its owner might in the Game On! UI.
+func (ms *MapService) DeleteSite(id string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.remove(id)
+}
+
+// EditSite calls edit with the registration of the site with the
+// given id and gives the site a new revision, as its owner might in
+// the Game On! UI. It returns false if there is no such site.
+func (ms *MapService) EditSite(id string, edit func(info *mapclient.RoomInfo)) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	s := ms.sites[id]
+	if s == nil {
+		return false
+	}
+	edit(s.Info)
+	s.Rev = bumpRev(s.Rev)
+	return true
+}
+
+// SetExit makes the exit from the site with the given id in direction
+// dir ("n", "s", "e" or "w") lead to x.
+func (ms *MapService) SetExit(id, dir string, x mapclient.Exit) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	s := ms.sites[id]
+	if s == nil {
+		return
+	}
+	if s.Exits == nil {
+		s.Exits = make(map[string]*mapclient.Exit)
+	}
+	s.Exits[dir] = &x
+}
+
+// Only call with ms.mu held.
+func (ms *MapService) add(owner string, info *mapclient.RoomInfo) *mapclient.Site {
+	ms.nextId++
+	s := &mapclient.Site{
+		Id:    fmt.Sprintf("site.%d", ms.nextId),
+		Rev:   "1-fake",
+		Owner: owner,
+		Info:  info,
+		Type:  "room",
+		Coord: &mapclient.Coord{X: ms.nextId, Y: 0},
+	}
+	ms.sites[s.Id] = s
+	ms.order = append(ms.order, s.Id)
+	return s
+}
+
+// Only call with ms.mu held.
+func (ms *MapService) remove(id string) {
+	delete(ms.sites, id)
+	for i, o := range ms.order {
+		if o == id {
+			ms.order = append(ms.order[:i], ms.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Returns a copy of s that shares nothing with it.
+func copySite(s *mapclient.Site) mapclient.Site {
+	b, _ := json.Marshal(s)
+	var c mapclient.Site
+	json.Unmarshal(b, &c)
+	return c
+}
+
+// Returns a copy of s as we serve it. Only call with ms.mu held.
+func (ms *MapService) shown(s *mapclient.Site) mapclient.Site {
+	c := copySite(s)
+	if ms.HideTokens && c.Info != nil && c.Info.ConnectionDetails != nil {
+		c.Info.ConnectionDetails.Token = ""
+	}
+	return c
+}
+
+// Returns the revision that follows rev, which is "<n>-fake".
+func bumpRev(rev string) string {
+	var n int
+	fmt.Sscanf(rev, "%d-", &n)
+	return fmt.Sprintf("%d-fake", n+1)
+}
+
+// ServeHTTP serves /map/v1/sites and /map/v1/sites/<id>.
+func (ms *MapService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ms.mu.Lock()
+	ms.calls++
+	ms.mu.Unlock()
+	w.Header().Set("Date", ms.now().UTC().Format(http.TimeFormat))
+	if ms.Fail != nil {
+		if status := ms.Fail(r); status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case r.URL.Path == sitesPath && r.Method == "GET":
+		ms.list(w, r)
+	case r.URL.Path == sitesPath && r.Method == "POST":
+		ms.create(w, r, body)
+	case strings.HasPrefix(r.URL.Path, sitesPath+"/"):
+		id := strings.TrimPrefix(r.URL.Path, sitesPath+"/")
+		switch r.Method {
+		case "GET":
+			ms.get(w, id)
+		case "PUT":
+			ms.update(w, r, body, id)
+		case "DELETE":
+			ms.delete(w, r, body, id)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ms *MapService) list(w http.ResponseWriter, r *http.Request) {
+	owner, name := r.URL.Query().Get("owner"), r.URL.Query().Get("name")
+	ms.mu.Lock()
+	sites := []mapclient.Site{}
+	for _, id := range ms.order {
+		s := ms.sites[id]
+		if (len(owner) > 0 && s.Owner != owner) || (len(name) > 0 && s.Info.Name != name) {
+			continue
+		}
+		sites = append(sites, ms.shown(s))
+	}
+	ms.mu.Unlock()
+	reply(w, http.StatusOK, sites)
+}
+
+func (ms *MapService) get(w http.ResponseWriter, id string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	s := ms.sites[id]
+	if s == nil {
+		http.Error(w, "No such site", http.StatusNotFound)
+		return
+	}
+	reply(w, http.StatusOK, ms.shown(s))
+}
+
+func (ms *MapService) create(w http.ResponseWriter, r *http.Request, body []byte) {
+	owner, ok := ms.authenticate(w, r, body)
+	if !ok {
+		return
+	}
+	info, ok := decodeInfo(w, body)
+	if !ok {
+		return
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, s := range ms.sites {
+		if s.Owner == owner && s.Info.Name == info.Name {
+			http.Error(w, "A site with that name already exists", http.StatusConflict)
+			return
+		}
+	}
+	reply(w, http.StatusCreated, ms.shown(ms.add(owner, info)))
+}
+
+func (ms *MapService) update(w http.ResponseWriter, r *http.Request, body []byte, id string) {
+	owner, ok := ms.authenticate(w, r, body)
+	if !ok {
+		return
+	}
+	info, ok := decodeInfo(w, body)
+	if !ok {
+		return
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	s := ms.sites[id]
+	switch {
+	case s == nil:
+		http.Error(w, "No such site", http.StatusNotFound)
+	case s.Owner != owner:
+		http.Error(w, "Not your site", http.StatusForbidden)
+	case len(r.Header.Get("If-Match")) > 0 && r.Header.Get("If-Match") != s.Rev:
+		http.Error(w, "Stale revision", http.StatusConflict)
+	default:
+		s.Info = info
+		s.Rev = bumpRev(s.Rev)
+		reply(w, http.StatusOK, ms.shown(s))
+	}
+}
+
+func (ms *MapService) delete(w http.ResponseWriter, r *http.Request, body []byte, id string) {
+	owner, ok := ms.authenticate(w, r, body)
+	if !ok {
+		return
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	s := ms.sites[id]
+	switch {
+	case s == nil:
+		http.Error(w, "No such site", http.StatusNotFound)
+	case s.Owner != owner:
+		http.Error(w, "Not your site", http.StatusForbidden)
+	default:
+		ms.remove(id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Checks the signature headers on r, writing a 401 and returning
+// false if they are not right. Otherwise returns the signer's id.
+func (ms *MapService) authenticate(w http.ResponseWriter, r *http.Request, body []byte) (string, bool) {
+	id := r.Header.Get("gameon-id")
+	date := r.Header.Get("gameon-date")
+	sig := r.Header.Get("gameon-signature")
+	ms.mu.Lock()
+	secret, known := ms.secrets[id]
+	ms.mu.Unlock()
+	fail := func(why string) (string, bool) {
+		http.Error(w, why, http.StatusUnauthorized)
+		return "", false
+	}
+	if !known {
+		return fail("Unknown gameon-id")
+	}
+	ts, err := http.ParseTime(date)
+	if err != nil {
+		ts, err = time.Parse(time.RFC1123, date)
+	}
+	if err != nil {
+		return fail("Unparseable gameon-date")
+	}
+	if d := ms.now().Sub(ts); d > ms.Skew || d < -ms.Skew {
+		return fail("gameon-date is too far from our clock")
+	}
+	tokens := id + date
+	if len(body) > 0 {
+		h := sha256.Sum256(body)
+		bodyHash := base64.StdEncoding.EncodeToString(h[:])
+		if r.Header.Get("gameon-sig-body") != bodyHash {
+			return fail("gameon-sig-body does not match the body")
+		}
+		tokens += bodyHash
+	}
+	if !hmac.Equal([]byte(sig), []byte(Sign(secret, tokens))) {
+		return fail("Bad gameon-signature")
+	}
+	return id, true
+}
+
+// Returns the time by our clock.
+func (ms *MapService) now() time.Time { return time.Now().Add(ms.Offset) }
+
+// Sign returns the base64 HMAC-SHA256 of s keyed with key, as Game On!
+// signs and checks requests and websocket handshakes.
+func Sign(key, s string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(s))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Decodes a registration, writing a 400 and returning false if body
+// is not one.
+func decodeInfo(w http.ResponseWriter, body []byte) (*mapclient.RoomInfo, bool) {
+	var info mapclient.RoomInfo
+	if err := json.Unmarshal(body, &info); err != nil || len(info.Name) == 0 {
+		http.Error(w, "Bad registration", http.StatusBadRequest)
+		return nil, false
+	}
+	return &info, true
+}
+
+// Writes v as JSON with the given status.
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,49 @@
+package gameontest
+
+import (
+	"context"
+	"net/http"
+	"sample-room-golang/gameon/mapclient"
+	"testing"
+)
+
+func TestMapServiceChecksSignatures(t *testing.T) {
+	ms := NewMapService()
+	defer ms.Close()
+	ms.AddUser("owner", "secret")
+	ctx := context.Background()
+
+	bad := mapclient.New(http.DefaultClient, ms.URL(), "owner", "not-the-secret")
+	bad.Retry.Attempts = 1
+	if _, err := bad.Create(ctx, &mapclient.RoomInfo{Name: "r"}); !mapclient.IsUnauthorized(err) {
+		t.Errorf("A badly signed create gave %v, not a 401", err)
+	}
+
+	mc := mapclient.New(http.DefaultClient, ms.URL(), "owner", "secret")
+	site, err := mc.Create(ctx, &mapclient.RoomInfo{Name: "r", FullName: "The Room"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := mc.Create(ctx, &mapclient.RoomInfo{Name: "r"}); !mapclient.IsConflict(err) {
+		t.Errorf("A second create of the same name gave %v, not a 409", err)
+	}
+	if _, err := mc.Update(ctx, site.Id, "0-stale", site.Info); !mapclient.IsConflict(err) {
+		t.Errorf("An update of a stale revision gave %v, not a 409", err)
+	}
+	if _, err := mc.Update(ctx, site.Id, site.Rev, &mapclient.RoomInfo{Name: "r", FullName: "Renamed"}); err != nil {
+		t.Errorf("Update failed: %v", err)
+	}
+	sites, err := mc.List(ctx, mapclient.ListOptions{Owner: "owner"})
+	if err != nil || len(sites) != 1 || sites[0].Info.FullName != "Renamed" {
+		t.Errorf("List returned %+v, %v", sites, err)
+	}
+	if err := bad.Delete(ctx, site.Id); !mapclient.IsUnauthorized(err) {
+		t.Errorf("A badly signed delete gave %v, not a 401", err)
+	}
+	if err := mc.Delete(ctx, site.Id); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if ms.Site(site.Id) != nil {
+		t.Errorf("The site survived its deletion.")
+	}
+}
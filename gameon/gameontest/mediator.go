@@ -0,0 +1,190 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+package gameontest
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sample-room-golang/gameon/protocol"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Mediator is a fake Game On! mediator connected to a room.
+type Mediator struct {
+	conn *websocket.Conn
+	// Versions lists the protocol versions in the room's ack.
+	Versions []int
+	// Timeout is how long Next waits for a frame. Dial sets it to
+	// five seconds.
+	Timeout time.Duration
+}
+
+// The date of the last handshake Dial signed.
+var (
+	lastDateMu sync.Mutex
+	lastDate   time.Time
+)
+
+// Returns the date with which to sign a handshake. A room refuses a
+// signature it has already accepted, and dates have a resolution of
+// one second, so no two handshakes get the same date; when several
+// are signed within a second the later ones are dated a little ahead.
+func handshakeDate() string {
+	lastDateMu.Lock()
+	defer lastDateMu.Unlock()
+	d := time.Now().UTC().Truncate(time.Second)
+	if !d.After(lastDate) {
+		d = lastDate.Add(time.Second)
+	}
+	lastDate = d
+	return d.Format(time.RFC1123)
+}
+
+// Dial opens a websocket to the room at url, signing the handshake
+// with key (the owner's secret, or the room's connection token), and
+// waits for the room's ack.
+func Dial(url, key string) (*Mediator, error) {
+	date := handshakeDate()
+	h := http.Header{}
+	h.Set("gameon-date", date)
+	h.Set("gameon-signature", Sign(key, date))
+	return DialWithHeader(url, h)
+}
+
+// DialWithHeader is like Dial but sends the handshake headers in h
+// as they are, which lets a test send a bad signature.
+func DialWithHeader(url string, h http.Header) (*Mediator, error) {
+	conn, resp, err := websocket.DefaultDialer.Dial(url, h)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%v (%s)", err, resp.Status)
+		}
+		return nil, err
+	}
+	m := &Mediator{conn: conn, Timeout: 5 * time.Second}
+	_, msg, err := m.Next()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ack, ok := msg.(*protocol.Ack)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("gameontest: expected an ack, got %T", msg)
+	}
+	m.Versions = ack.Version
+	return m, nil
+}
+
+// Close closes the connection as the mediator would.
+func (m *Mediator) Close() error {
+	deadline := time.Now().Add(time.Second)
+	m.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+	return m.conn.Close()
+}
+
+// Send sends msg to the room with the given id.
+func (m *Mediator) Send(roomId string, msg protocol.Message) error {
+	b, err := protocol.Encode(roomId, msg)
+	if err != nil {
+		return err
+	}
+	return m.SendRaw(b)
+}
+
+// SendRaw sends b as it is, which lets a test send a malformed frame.
+func (m *Mediator) SendRaw(b []byte) error {
+	return m.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// Hello tells the room that a player has entered it.
+func (m *Mediator) Hello(roomId, userId, username string, version int) error {
+	return m.Send(roomId, &protocol.Hello{Version: version, UserId: userId, Username: username})
+}
+
+// Say sends something a player typed in the room: a slash command or
+// something to say.
+func (m *Mediator) Say(roomId, userId, username, content string) error {
+	return m.Send(roomId, &protocol.Command{UserId: userId, Username: username, Content: content})
+}
+
+// Goodbye tells the room that a player has left it.
+func (m *Mediator) Goodbye(roomId, userId, username string) error {
+	return m.Send(roomId, &protocol.Goodbye{UserId: userId, Username: username})
+}
+
+// Next returns the next frame from the room and its decoded message.
+func (m *Mediator) Next() (*protocol.Frame, protocol.Message, error) {
+	m.conn.SetReadDeadline(time.Now().Add(m.Timeout))
+	_, b, err := m.conn.ReadMessage()
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := protocol.ParseFrame(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := f.Decode()
+	return f, msg, err
+}
+
+// Expect reads frames until one is for target ("*" for a broadcast)
+// and its message satisfies match, and returns that message. Frames
+// that do not match are skipped. It fails if none matches within
+// Timeout of the previous frame.
+func (m *Mediator) Expect(target string, match func(protocol.Message) bool) (protocol.Message, error) {
+	var skipped []string
+	for {
+		f, msg, err := m.Next()
+		if err != nil {
+			return nil, fmt.Errorf("gameontest: %v after skipping %s", err, strings.Join(skipped, "; "))
+		}
+		if f.Target == target && match(msg) {
+			return msg, nil
+		}
+		skipped = append(skipped, string(f.Bytes()))
+	}
+}
+
+// ExpectEvent waits for an event for userId whose text for that user
+// contains text.
+func (m *Mediator) ExpectEvent(userId, text string) (*protocol.Event, error) {
+	msg, err := m.Expect(userId, func(msg protocol.Message) bool {
+		ev, ok := msg.(*protocol.Event)
+		return ok && strings.Contains(ev.Content[userId], text)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg.(*protocol.Event), nil
+}
+
+// ExpectLocation waits for the location response sent to userId.
+func (m *Mediator) ExpectLocation(userId string) (*protocol.Location, error) {
+	msg, err := m.Expect(userId, func(msg protocol.Message) bool {
+		_, ok := msg.(*protocol.Location)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg.(*protocol.Location), nil
+}
+
+// ExpectExit waits for the room to move userId out through a door.
+func (m *Mediator) ExpectExit(userId string) (*protocol.Exit, error) {
+	msg, err := m.Expect(userId, func(msg protocol.Message) bool {
+		_, ok := msg.(*protocol.Exit)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg.(*protocol.Exit), nil
+}
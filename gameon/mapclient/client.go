@@ -0,0 +1,255 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+package mapclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The most of a response body that we will read.
+const maxResponseSize = 4 << 20
+
+// The most of an unexpected response body kept in a StatusError.
+const maxErrorBody = 512
+
+// Backoff describes how failed attempts are retried. The n'th retry
+// (counting from 0) waits Initial*Multiplier^n, capped at Max, less a
+// random fraction of up to Jitter of that.
+type Backoff struct {
+	// The total number of attempts; 1 means do not retry.
+	Attempts   int
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// Between 0 and 1.
+	Jitter float64
+}
+
+// DefaultBackoff is used by clients made with New.
+var DefaultBackoff = Backoff{
+	Attempts:   5,
+	Initial:    time.Second,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.5,
+}
+
+// Returns how long to wait before retry n.
+func (b Backoff) delay(n int) time.Duration {
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(n))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	d -= d * b.Jitter * rand.Float64()
+	return time.Duration(d)
+}
+
+// A Client talks to one map service.
+type Client struct {
+	HTTP *http.Client
+	// BaseURL is the map service's address up to and including its
+	// version, for example https://gameontext.org/map/v1 .
+	BaseURL string
+	// The Game On! id and shared secret used to sign requests.
+	Id     string
+	Secret string
+	// If CurrentSecret is not nil it returns the secret to sign with
+	// in place of Secret, so that the secret can change while the
+	// client is in use.
+	CurrentSecret func() string
+	// Timeout bounds each attempt. Zero means no limit beyond the
+	// caller's context.
+	Timeout time.Duration
+	Retry   Backoff
+	// Date returns the gameon-date for a signed request. The map
+	// service rejects dates too far from its own clock, so a client
+	// whose clock is known to be off may shift it here.
+	Date func() string
+	// If DateSeen is not nil it is told the Date of each response,
+	// with when the request was sent and the response received, so
+	// that a client can measure how far its clock is from the map
+	// service's and correct Date to match.
+	DateSeen func(date, sent, received time.Time)
+	// If Logf is not nil it is told about each attempt.
+	Logf func(format string, args ...interface{})
+}
+
+// New returns a client for the map service at baseURL that signs its
+// requests with id and secret.
+func New(hc *http.Client, baseURL, id, secret string) *Client {
+	return &Client{
+		HTTP:    hc,
+		BaseURL: baseURL,
+		Id:      id,
+		Secret:  secret,
+		Timeout: 30 * time.Second,
+		Retry:   DefaultBackoff,
+	}
+}
+
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.Logf != nil {
+		c.Logf(format, args...)
+	}
+}
+
+func (c *Client) secret() string {
+	if c.CurrentSecret != nil {
+		return c.CurrentSecret()
+	}
+	return c.Secret
+}
+
+func (c *Client) date() string {
+	if c.Date != nil {
+		return c.Date()
+	}
+	return time.Now().UTC().Format(time.RFC1123)
+}
+
+// A call is one logical request to the map service.
+type call struct {
+	// Names the call in errors, for example "create site".
+	op     string
+	method string
+	path   string
+	query  url.Values
+	// If in is not nil it is marshalled as the request body.
+	in     interface{}
+	signed bool
+	// The status codes that mean success.
+	want []int
+	// If out is not nil a successful response body is unmarshalled
+	// into it.
+	out interface{}
+	// Extra request headers.
+	header http.Header
+	// Set by do if it repeated the call after an attempt that may
+	// have reached the map service.
+	retried bool
+}
+
+// Makes the call, retrying as c.Retry allows. Returns the status code
+// of the successful response.
+func (c *Client) do(ctx context.Context, cl *call) (status int, err error) {
+	var body []byte
+	if cl.in != nil {
+		body, err = json.Marshal(cl.in)
+		if err != nil {
+			return
+		}
+	}
+	attempts := c.Retry.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	resigned := false
+	for n := 0; ; n++ {
+		var retry bool
+		status, retry, err = c.attempt(ctx, cl, body)
+		if cl.signed && !resigned && IsExpired(err) && ctx.Err() == nil {
+			// DateSeen may have corrected our clock meanwhile, so
+			// sign again with a fresh date, once, straight away.
+			resigned = true
+			c.logf("%s: date rejected, signing again: %v", cl.op, err)
+			status, retry, err = c.attempt(ctx, cl, body)
+		}
+		if err == nil || !retry || n+1 >= attempts {
+			return
+		}
+		d := c.Retry.delay(n)
+		c.logf("%s: attempt %d of %d failed, retrying in %v: %v", cl.op, n+1, attempts, d, err)
+		cl.retried = true
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Makes one attempt at the call. retry is true if a failed attempt
+// might succeed if repeated.
+func (c *Client) attempt(ctx context.Context, cl *call, body []byte) (status int, retry bool, err error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	u := c.BaseURL + cl.path
+	if len(cl.query) > 0 {
+		u += "?" + cl.query.Encode()
+	}
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(cl.method, u, rd)
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json,text/plain")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, vs := range cl.header {
+		req.Header[k] = vs
+	}
+	if cl.signed {
+		SignRequest(req, c.Id, c.secret(), c.date(), body)
+	}
+	c.logf("%s: %s %s", cl.op, cl.method, u)
+
+	sent := time.Now()
+	resp, err := c.HTTP.Do(req)
+	if err == nil && c.DateSeen != nil {
+		if date, e := http.ParseTime(resp.Header.Get("Date")); e == nil {
+			c.DateSeen(date, sent, time.Now())
+		}
+	}
+	if err != nil {
+		// A refused connection or a timed out attempt may well
+		// succeed later. If it was the caller's context that ended,
+		// do returns its error rather than waiting to retry.
+		retry = true
+		return
+	}
+	defer resp.Body.Close()
+	rb, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		retry = true
+		return
+	}
+	status = resp.StatusCode
+	for _, w := range cl.want {
+		if status != w {
+			continue
+		}
+		if cl.out != nil && len(bytes.TrimSpace(rb)) > 0 {
+			if e := json.Unmarshal(rb, cl.out); e != nil {
+				err = fmt.Errorf("mapclient: %s: bad response: %s", cl.op, e.Error())
+			}
+		}
+		return
+	}
+	if len(rb) > maxErrorBody {
+		rb = rb[:maxErrorBody]
+	}
+	se := &StatusError{Op: cl.op, StatusCode: status, Status: resp.Status, Body: string(rb)}
+	return status, se.Temporary(), se
+}
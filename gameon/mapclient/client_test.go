@@ -0,0 +1,177 @@
+package mapclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testClient(url string) *Client {
+	c := New(http.DefaultClient, url+"/map/v1", "test-id", "test-secret")
+	c.Retry = Backoff{Attempts: 3, Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 2}
+	return c
+}
+
+func TestCreateSignsAndDecodes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/map/v1/sites" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var info RoomInfo
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			t.Errorf("Bad request body: %v", err)
+		}
+		b, _ := json.Marshal(&info)
+		date := r.Header.Get("gameon-date")
+		want := HMAC("test-secret", "test-id", date, BodyHash(b))
+		if r.Header.Get("gameon-signature") != want {
+			t.Errorf("Bad signature %q, want %q", r.Header.Get("gameon-signature"), want)
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&Site{Id: "site.1", Owner: "test-id", Info: &info})
+	}))
+	defer srv.Close()
+
+	site, err := testClient(srv.URL).Create(context.Background(), &RoomInfo{Name: "r", FullName: "The Room"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if site.Id != "site.1" || site.Info == nil || site.Info.FullName != "The Room" {
+		t.Errorf("Create returned %#v", site)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	cases := []struct {
+		status int
+		is     func(error) bool
+	}{
+		{http.StatusUnauthorized, IsUnauthorized},
+		{http.StatusForbidden, IsForbidden},
+		{http.StatusNotFound, IsNotFound},
+		{http.StatusConflict, IsConflict},
+	}
+	for _, tc := range cases {
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(tc.status)
+		}))
+		err := testClient(srv.URL).Delete(context.Background(), "site.1")
+		srv.Close()
+		if !tc.is(err) {
+			t.Errorf("Status %d gave %v", tc.status, err)
+		}
+		if calls != 1 {
+			t.Errorf("Status %d was tried %d times, want 1", tc.status, calls)
+		}
+	}
+}
+
+func TestRetriesServerErrors(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sites, err := testClient(srv.URL).List(context.Background(), ListOptions{Owner: "test-id"})
+	if err != nil {
+		t.Fatalf("List failed after %d calls: %v", calls, err)
+	}
+	if len(sites) != 0 || calls != 3 {
+		t.Errorf("List returned %d sites after %d calls", len(sites), calls)
+	}
+}
+
+func TestContextCancelStopsRetries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := testClient(srv.URL)
+	c.Retry = Backoff{Attempts: 10, Initial: time.Hour, Max: time.Hour, Multiplier: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, "site.1"); err != context.DeadlineExceeded {
+		t.Errorf("Get returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestExpiredDateIsSignedAgain(t *testing.T) {
+	var dates []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dates = append(dates, r.Header.Get("gameon-date"))
+		if len(dates) == 1 {
+			http.Error(w, "Signed request has expired", http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := testClient(srv.URL)
+	c.Retry.Attempts = 1
+	n := 0
+	c.Date = func() string {
+		n++
+		return fmt.Sprintf("date %d", n)
+	}
+	seen := 0
+	c.DateSeen = func(date, sent, received time.Time) { seen++ }
+	if err := c.Delete(context.Background(), "site.1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if len(dates) != 2 || dates[0] == dates[1] {
+		t.Errorf("Expected two differently dated attempts, got %v", dates)
+	}
+	if seen != 2 {
+		t.Errorf("DateSeen was called %d times, not 2", seen)
+	}
+}
+
+func TestRetriedCreateFindsSite(t *testing.T) {
+	var posts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			posts++
+			// The first attempt registers the site but its response
+			// is lost, so the second finds the name taken.
+			if posts == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+			} else {
+				w.WriteHeader(http.StatusConflict)
+			}
+		case r.Method == "GET" && r.URL.Query().Get("name") == "r" && r.URL.Query().Get("owner") == "test-id":
+			json.NewEncoder(w).Encode([]Site{{Id: "site.1", Owner: "test-id", Info: &RoomInfo{Name: "r"}}})
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	defer srv.Close()
+
+	site, err := testClient(srv.URL).Create(context.Background(), &RoomInfo{Name: "r"})
+	if err != nil || site.Id != "site.1" {
+		t.Errorf("Create returned %#v, %v", site, err)
+	}
+	if posts != 2 {
+		t.Errorf("Made %d POSTs, want 2", posts)
+	}
+
+	// A conflict on the first attempt is a conflict.
+	posts = 1
+	if _, err := testClient(srv.URL).Create(context.Background(), &RoomInfo{Name: "r"}); !IsConflict(err) {
+		t.Errorf("A first attempt that conflicted gave %v", err)
+	}
+}
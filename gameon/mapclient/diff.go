@@ -0,0 +1,119 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+package mapclient
+
+// A Change is one field that differs between two registrations.
+type Change struct {
+	// The field's JSON path, for example "doors.n".
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Diff returns the fields of want that differ from have. Fields that
+// are empty in want are left out: an owner may have set them through
+// Game On! itself, and we do not want to undo that. The map service
+// may keep the connection token to itself, so a token in want is
+// reported as changed unless have shows the same one; the caller may
+// know better and drop that change.
+func Diff(have, want *RoomInfo) []Change {
+	if have == nil {
+		have = &RoomInfo{}
+	}
+	if want == nil {
+		return nil
+	}
+	var changes []Change
+	note := func(field, old, new string) {
+		if len(new) > 0 && old != new {
+			changes = append(changes, Change{field, old, new})
+		}
+	}
+	note("name", have.Name, want.Name)
+	note("fullName", have.FullName, want.FullName)
+	note("description", have.Description, want.Description)
+
+	hc, wc := have.ConnectionDetails, want.ConnectionDetails
+	if hc == nil {
+		hc = &ConnectionDetails{}
+	}
+	if wc != nil {
+		note("connectionDetails.type", hc.Type, wc.Type)
+		note("connectionDetails.target", hc.Target, wc.Target)
+		old := "(hidden)"
+		if len(hc.Token) == 0 {
+			old = "(unknown)"
+		}
+		note("connectionDetails.token", old, tokenChange(hc.Token, wc.Token))
+	}
+
+	hd, wd := have.Doors, want.Doors
+	if hd == nil {
+		hd = &Doors{}
+	}
+	if wd != nil {
+		note("doors.n", hd.North, wd.North)
+		note("doors.s", hd.South, wd.South)
+		note("doors.e", hd.East, wd.East)
+		note("doors.w", hd.West, wd.West)
+		note("doors.u", hd.Up, wd.Up)
+		note("doors.d", hd.Down, wd.Down)
+	}
+	return changes
+}
+
+// Returns "" if the tokens are the same, so that no change is noted,
+// and something that does not reveal either token otherwise.
+func tokenChange(have, want string) string {
+	if have == want || len(want) == 0 {
+		return ""
+	}
+	return "(new token)"
+}
+
+// Merge returns a copy of have with the fields that are set in want
+// replacing its own, which is what to send to Update so that fields
+// that Diff ignores survive.
+func Merge(have, want *RoomInfo) *RoomInfo {
+	var m RoomInfo
+	if have != nil {
+		m = *have
+	}
+	if want == nil {
+		return &m
+	}
+	pick := func(dst *string, src string) {
+		if len(src) > 0 {
+			*dst = src
+		}
+	}
+	pick(&m.Name, want.Name)
+	pick(&m.FullName, want.FullName)
+	pick(&m.Description, want.Description)
+	if want.ConnectionDetails != nil {
+		var c ConnectionDetails
+		if m.ConnectionDetails != nil {
+			c = *m.ConnectionDetails
+		}
+		pick(&c.Type, want.ConnectionDetails.Type)
+		pick(&c.Target, want.ConnectionDetails.Target)
+		pick(&c.Token, want.ConnectionDetails.Token)
+		m.ConnectionDetails = &c
+	}
+	if want.Doors != nil {
+		var d Doors
+		if m.Doors != nil {
+			d = *m.Doors
+		}
+		pick(&d.North, want.Doors.North)
+		pick(&d.South, want.Doors.South)
+		pick(&d.East, want.Doors.East)
+		pick(&d.West, want.Doors.West)
+		pick(&d.Up, want.Doors.Up)
+		pick(&d.Down, want.Doors.Down)
+		m.Doors = &d
+	}
+	return &m
+}
@@ -0,0 +1,62 @@
+package mapclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffAndMerge(t *testing.T) {
+	have := &RoomInfo{
+		Name:              "r",
+		FullName:          "The Room",
+		Description:       "Set in the Game On! UI",
+		ConnectionDetails: &ConnectionDetails{Type: "websocket", Target: "ws://old:3000"},
+		Doors:             &Doors{North: "A door", South: "Another door"},
+	}
+	want := &RoomInfo{
+		Name:              "r",
+		FullName:          "The Grand Room",
+		ConnectionDetails: &ConnectionDetails{Type: "websocket", Target: "ws://new:3000"},
+		Doors:             &Doors{North: "A door", East: "A new door"},
+	}
+	got := Diff(have, want)
+	expected := []Change{
+		{"fullName", "The Room", "The Grand Room"},
+		{"connectionDetails.target", "ws://old:3000", "ws://new:3000"},
+		{"doors.e", "", "A new door"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Diff = %v, want %v", got, expected)
+	}
+
+	m := Merge(have, want)
+	if changes := Diff(m, want); len(changes) != 0 {
+		t.Errorf("Merge left changes %v", changes)
+	}
+	if m.Description != have.Description || m.Doors.South != have.Doors.South {
+		t.Errorf("Merge lost fields that want leaves empty: %#v", m)
+	}
+	if have.FullName != "The Room" || have.Doors.East != "" {
+		t.Errorf("Merge modified its argument: %#v", have)
+	}
+}
+
+func TestDiffToken(t *testing.T) {
+	want := &RoomInfo{ConnectionDetails: &ConnectionDetails{Token: "t"}}
+	for _, tc := range []struct {
+		have    string
+		changed bool
+	}{
+		{"", true},
+		{"old", true},
+		{"t", false},
+	} {
+		have := &RoomInfo{ConnectionDetails: &ConnectionDetails{Token: tc.have}}
+		if changes := Diff(have, want); (len(changes) > 0) != tc.changed {
+			t.Errorf("With a registered token of %q, Diff = %v", tc.have, changes)
+		}
+	}
+	if changes := Diff(want, &RoomInfo{ConnectionDetails: &ConnectionDetails{}}); len(changes) != 0 {
+		t.Errorf("Wanting no token, Diff = %v", changes)
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Package mapclient is a client for the Game On! map service, which
+// keeps the registry of sites (rooms) under /map/v1/sites.
+//
+// Reads (List and Get) may be made anonymously. Everything else is
+// signed with the Game On! id and shared secret that were issued when
+// the owner logged in to Game On!; see SignRequest.
+//
+// Every call takes a context. Each attempt is also bounded by
+// Client.Timeout, and attempts that fail in a way that may succeed
+// later (network errors, 5xx and 429 responses) are retried with
+// exponential backoff and jitter as described by Client.Retry. Other
+// failures are returned at once as a *StatusError, which IsNotFound,
+// IsConflict, IsUnauthorized and IsForbidden recognize. Since a failed
+// create may have registered the site all the same, Create looks the
+// site up if its retry finds the name taken.
+package mapclient
@@ -0,0 +1,77 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+package mapclient
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A StatusError describes a response from the map service with a
+// status code that the call did not expect.
+type StatusError struct {
+	// Op names the call, for example "create site".
+	Op         string
+	StatusCode int
+	Status     string
+	// Body is the start of the response body, which usually says
+	// what went wrong.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Body) == 0 {
+		return fmt.Sprintf("mapclient: %s: %s", e.Op, e.Status)
+	}
+	return fmt.Sprintf("mapclient: %s: %s: %s", e.Op, e.Status, e.Body)
+}
+
+// Temporary reports whether the same request might succeed later.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Reports whether err is a *StatusError with the given status code.
+func hasStatus(err error, code int) bool {
+	se, ok := err.(*StatusError)
+	return ok && se.StatusCode == code
+}
+
+// IsUnauthorized reports whether the map service did not accept our
+// id or signature (401). A clock that is too far from the map
+// service's also causes this.
+func IsUnauthorized(err error) bool { return hasStatus(err, http.StatusUnauthorized) }
+
+// IsForbidden reports whether we are not allowed to do what we asked,
+// such as changing a site that someone else owns (403).
+func IsForbidden(err error) bool { return hasStatus(err, http.StatusForbidden) }
+
+// IsNotFound reports whether the site does not exist (404).
+func IsNotFound(err error) bool { return hasStatus(err, http.StatusNotFound) }
+
+// IsExpired reports whether the map service refused a signed request
+// because its gameon-date was too far from the map service's clock.
+// The map service says so only in the response body, so this looks
+// there for words such as "expired".
+func IsExpired(err error) bool {
+	if !hasStatus(err, http.StatusUnauthorized) && !hasStatus(err, http.StatusForbidden) {
+		return false
+	}
+	body := strings.ToLower(err.(*StatusError).Body)
+	for _, w := range []string{"expired", "timestamp", "gameon-date", "clock"} {
+		if strings.Contains(body, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsConflict reports whether the request conflicts with the current
+// state of the site, for example a create of a name that is already
+// registered (409) or an update of a stale revision (409 or 412).
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict) || hasStatus(err, http.StatusPreconditionFailed)
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+package mapclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// BodyHash returns the base64 SHA-256 digest of body, as sent in the
+// gameon-sig-body header.
+func BodyHash(body []byte) string {
+	h := sha256.Sum256(body)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// HMAC returns the base64 HMAC-SHA256, keyed with secret, of the
+// concatenated tokens.
+func HMAC(secret string, tokens ...string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strings.Join(tokens, "")))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// SignRequest adds the headers with which Game On! authenticates a
+// request: our id, the date, a hash of body and a signature over all
+// three. An empty body is left out of the signature.
+func SignRequest(req *http.Request, id, secret, date string, body []byte) {
+	bodyHash := BodyHash(body)
+	var sig string
+	if len(body) > 0 {
+		sig = HMAC(secret, id, date, bodyHash)
+	} else {
+		sig = HMAC(secret, id, date)
+	}
+	req.Header.Set("gameon-id", id)
+	req.Header.Set("gameon-date", date)
+	req.Header.Set("gameon-sig-body", bodyHash)
+	req.Header.Set("gameon-signature", sig)
+}
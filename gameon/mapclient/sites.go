@@ -0,0 +1,193 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+package mapclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// ConnectionDetails tells the mediator how to reach a room.
+type ConnectionDetails struct {
+	// Always "websocket".
+	Type   string `json:"type,omitempty"`
+	Target string `json:"target,omitempty"`
+	// If set, the mediator signs its handshake with Token instead of
+	// the owner's secret. The map service may not return it.
+	Token string `json:"token,omitempty"`
+}
+
+// Doors describes the outside of each of a room's doors, as seen from
+// the neighbouring room. Game On! currently ignores Up and Down.
+type Doors struct {
+	North string `json:"n,omitempty"`
+	South string `json:"s,omitempty"`
+	East  string `json:"e,omitempty"`
+	West  string `json:"w,omitempty"`
+	Up    string `json:"u,omitempty"`
+	Down  string `json:"d,omitempty"`
+}
+
+// RoomInfo is what a room's owner registers.
+type RoomInfo struct {
+	Name              string             `json:"name,omitempty"`
+	FullName          string             `json:"fullName,omitempty"`
+	Description       string             `json:"description,omitempty"`
+	ConnectionDetails *ConnectionDetails `json:"connectionDetails,omitempty"`
+	Doors             *Doors             `json:"doors,omitempty"`
+}
+
+// An Exit is a door out of a site and the neighbouring site that it
+// leads to.
+type Exit struct {
+	// The neighbour's id and names.
+	Id       string `json:"_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	FullName string `json:"fullName,omitempty"`
+	// Door describes the door as seen from inside the site that it
+	// leads out of, which is what the neighbour registered for its
+	// opposite door.
+	Door              string             `json:"door,omitempty"`
+	ConnectionDetails *ConnectionDetails `json:"connectionDetails,omitempty"`
+}
+
+// Coord is where the map service has placed a site.
+type Coord struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// A Site is a registered room as the map service describes it.
+type Site struct {
+	Id    string    `json:"_id,omitempty"`
+	Rev   string    `json:"_rev,omitempty"`
+	Owner string    `json:"owner,omitempty"`
+	Info  *RoomInfo `json:"info,omitempty"`
+	Type  string    `json:"type,omitempty"`
+	// The site's exits, keyed by direction: "n", "s", "e", "w", "u"
+	// or "d". They are chosen by the map service, not by the owner.
+	Exits map[string]*Exit `json:"exits,omitempty"`
+	Coord *Coord           `json:"coord,omitempty"`
+}
+
+// ListOptions narrows List to the sites with a given owner and/or
+// name. Empty fields are ignored.
+type ListOptions struct {
+	Owner string
+	Name  string
+}
+
+// List returns the sites that match opts. It returns an empty slice,
+// not an error, if there are none.
+func (c *Client) List(ctx context.Context, opts ListOptions) ([]Site, error) {
+	q := url.Values{}
+	if len(opts.Owner) > 0 {
+		q.Set("owner", opts.Owner)
+	}
+	if len(opts.Name) > 0 {
+		q.Set("name", opts.Name)
+	}
+	var sites []Site
+	_, err := c.do(ctx, &call{
+		op:     "list sites",
+		method: "GET",
+		path:   "/sites",
+		query:  q,
+		signed: len(c.Id) > 0,
+		want:   []int{http.StatusOK, http.StatusNoContent},
+		out:    &sites,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sites, nil
+}
+
+// Get returns the site with the given id.
+func (c *Client) Get(ctx context.Context, id string) (*Site, error) {
+	var site Site
+	_, err := c.do(ctx, &call{
+		op:     "get site",
+		method: "GET",
+		path:   "/sites/" + url.PathEscape(id),
+		want:   []int{http.StatusOK},
+		out:    &site,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &site, nil
+}
+
+// Create registers a new site. A name that is already registered
+// fails with an error for which IsConflict is true.
+//
+// A POST is not idempotent: an attempt that timed out or failed with a
+// 5xx may have registered the site all the same, and the retry then
+// finds the name taken. So if a retried create fails with a conflict
+// we look the site up, and return it if we own it.
+func (c *Client) Create(ctx context.Context, info *RoomInfo) (*Site, error) {
+	var site Site
+	cl := &call{
+		op:     "create site",
+		method: "POST",
+		path:   "/sites",
+		in:     info,
+		signed: true,
+		want:   []int{http.StatusCreated},
+		out:    &site,
+	}
+	_, err := c.do(ctx, cl)
+	if IsConflict(err) && cl.retried && info != nil {
+		c.logf("%s: conflict after a retry, looking for %s", cl.op, info.Name)
+		sites, lerr := c.List(ctx, ListOptions{Owner: c.Id, Name: info.Name})
+		if lerr == nil && len(sites) > 0 {
+			return &sites[0], nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &site, nil
+}
+
+// Update replaces the registration of the site with the given id. If
+// rev is not empty the update only succeeds if it is still the site's
+// current revision; otherwise it fails with an error for which
+// IsConflict is true.
+func (c *Client) Update(ctx context.Context, id, rev string, info *RoomInfo) (*Site, error) {
+	var h http.Header
+	if len(rev) > 0 {
+		h = http.Header{"If-Match": {rev}}
+	}
+	var site Site
+	_, err := c.do(ctx, &call{
+		op:     "update site",
+		method: "PUT",
+		path:   "/sites/" + url.PathEscape(id),
+		in:     info,
+		signed: true,
+		want:   []int{http.StatusOK},
+		out:    &site,
+		header: h,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &site, nil
+}
+
+// Delete removes the site with the given id.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	_, err := c.do(ctx, &call{
+		op:     "delete site",
+		method: "DELETE",
+		path:   "/sites/" + url.PathEscape(id),
+		signed: true,
+		want:   []int{http.StatusNoContent, http.StatusOK},
+	})
+	return err
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// A Frame is one websocket text message, split into its parts but
+// with its payload not yet decoded.
+type Frame struct {
+	Kind string
+	// Target is empty for an ack.
+	Target  string
+	Payload []byte
+}
+
+// ParseFrame splits b into kind, target and payload. Commas inside
+// the payload are left alone. The payload itself is not inspected.
+func ParseFrame(b []byte) (*Frame, error) {
+	i := bytes.IndexByte(b, ',')
+	if i < 1 {
+		return nil, &FrameError{"missing kind"}
+	}
+	f := Frame{Kind: string(b[:i])}
+	rest := b[i+1:]
+	if f.Kind != KindAck {
+		j := bytes.IndexByte(rest, ',')
+		if j < 1 {
+			return nil, &FrameError{"missing target"}
+		}
+		f.Target = string(rest[:j])
+		rest = rest[j+1:]
+	}
+	if len(rest) == 0 {
+		return nil, &FrameError{"missing payload"}
+	}
+	f.Payload = rest
+	return &f, nil
+}
+
+// Bytes reassembles the frame.
+func (f *Frame) Bytes() []byte {
+	var b bytes.Buffer
+	b.WriteString(f.Kind)
+	b.WriteByte(',')
+	if f.Kind != KindAck {
+		b.WriteString(f.Target)
+		b.WriteByte(',')
+	}
+	b.Write(f.Payload)
+	return b.Bytes()
+}
+
+// Decode unmarshals and validates the frame's payload according to
+// its kind. The concrete type of the result is one of *Ack, *Hello,
+// *Goodbye, *Join, *Part, *Command, *Event, *Chat, *Location or *Exit.
+func (f *Frame) Decode() (Message, error) {
+	var m Message
+	switch f.Kind {
+	case KindAck:
+		m = &Ack{}
+	case KindRoomHello:
+		m = &Hello{}
+	case KindRoomGoodbye:
+		m = &Goodbye{}
+	case KindRoomJoin:
+		m = &Join{}
+	case KindRoomPart:
+		m = &Part{}
+	case KindRoom:
+		m = &Command{}
+	case KindPlayerLocation:
+		m = &Exit{}
+	case KindPlayer:
+		var peek struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(f.Payload, &peek); err != nil {
+			return nil, &PayloadError{f.Kind, err}
+		}
+		switch peek.Type {
+		case TypeEvent:
+			m = &Event{}
+		case TypeChat:
+			m = &Chat{}
+		case TypeLocation:
+			m = &Location{}
+		default:
+			return nil, invalid(f.Kind, "type", "unknown type '"+peek.Type+"'")
+		}
+	default:
+		return nil, &UnknownKindError{f.Kind}
+	}
+	if err := json.Unmarshal(f.Payload, m); err != nil {
+		return nil, &PayloadError{f.Kind, err}
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Decode parses b and decodes its payload. See Frame.Decode.
+func Decode(b []byte) (target string, m Message, err error) {
+	f, err := ParseFrame(b)
+	if err != nil {
+		return
+	}
+	m, err = f.Decode()
+	if err != nil {
+		return
+	}
+	target = f.Target
+	return
+}
+
+// Encode validates m and frames it for target. target is ignored for
+// an *Ack and required for everything else.
+func Encode(target string, m Message) ([]byte, error) {
+	f, err := NewFrame(target, m)
+	if err != nil {
+		return nil, err
+	}
+	return f.Bytes(), nil
+}
+
+// NewFrame validates m and marshals it into a frame for target.
+func NewFrame(target string, m Message) (*Frame, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	kind := m.Kind()
+	if kind == KindAck {
+		target = ""
+	} else if target == "" {
+		return nil, &FrameError{"missing target"}
+	}
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, &PayloadError{kind, err}
+	}
+	return &Frame{Kind: kind, Target: target, Payload: j}, nil
+}
+
+// PeekUserId makes a best effort to find the userId field in a
+// payload that may not otherwise be valid. It returns "" if there
+// is none.
+func PeekUserId(payload []byte) string {
+	var who struct {
+		UserId string `json:"userId"`
+	}
+	if json.Unmarshal(payload, &who) != nil {
+		return ""
+	}
+	return who.UserId
+}
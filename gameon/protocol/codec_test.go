@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+var roundTrips = []struct {
+	target string
+	m      Message
+}{
+	{"", &Ack{Version: []int{1, 2}}},
+	{"room.1", &Hello{Version: 2, UserId: "dummy.DevUser", Username: "DevUser"}},
+	{"room.1", &Join{Version: 2, UserId: "dummy.DevUser", Username: "DevUser"}},
+	{"room.1", &Goodbye{UserId: "dummy.DevUser", Username: "DevUser"}},
+	{"room.1", &Part{UserId: "dummy.DevUser", Username: "DevUser"}},
+	{"room.1", &Command{UserId: "dummy.DevUser", Username: "DevUser", Content: "/examine book, then the shelf"}},
+	{"dummy.DevUser", NewEvent("dummy.DevUser", "There is no book here.")},
+	{"*", NewChat("DevUser", "Hello, everyone.")},
+	{"*", NewChat("", "...and another thing.")},
+	{"dummy.DevUser", &Location{Type: TypeLocation, Name: "r", FullName: "The Room",
+		Description: "This is The Room", Commands: map[string]string{"/wink": "Wink"}}},
+	{"dummy.DevUser", NewExit("N", "You head north.")},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, rt := range roundTrips {
+		b, err := Encode(rt.target, rt.m)
+		if err != nil {
+			t.Errorf("Encode(%T) failed: %v", rt.m, err)
+			continue
+		}
+		target, m, err := Decode(b)
+		if err != nil {
+			t.Errorf("Decode(%s) failed: %v", b, err)
+			continue
+		}
+		if target != rt.target {
+			t.Errorf("Decode(%s) target = %q, want %q", b, target, rt.target)
+		}
+		if !reflect.DeepEqual(m, rt.m) {
+			t.Errorf("Decode(%s) = %#v, want %#v", b, m, rt.m)
+		}
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	bad := []string{
+		"",
+		"roomHello",
+		"roomHello,room.1",
+		"roomHello,room.1,",
+		",room.1,{}",
+		"nonsense,room.1,{}",
+		"roomHello,room.1,{not json",
+		`roomHello,room.1,{"username":"DevUser"}`,
+		`roomHello,room.1,{"userId":"dummy.DevUser","username":"DevUser","version":-1}`,
+		`room,room.1,{"userId":"dummy.DevUser","username":"DevUser"}`,
+		`player,dummy.DevUser,{"type":"shout","content":"hi"}`,
+		`playerLocation,dummy.DevUser,{"type":"exit"}`,
+		`ack,{"version":[]}`,
+	}
+	for _, s := range bad {
+		if _, m, err := Decode([]byte(s)); err == nil {
+			t.Errorf("Decode(%q) = %#v, want an error", s, m)
+		}
+	}
+}
+
+func TestEncodeRejects(t *testing.T) {
+	if _, err := Encode("", NewEvent("dummy.DevUser", "hi")); err == nil {
+		t.Errorf("Encode accepted an event without a target.")
+	}
+	if _, err := Encode("room.1", &Command{UserId: "dummy.DevUser"}); err == nil {
+		t.Errorf("Encode accepted a command without a username or content.")
+	}
+}
+
+func TestPeekUserId(t *testing.T) {
+	if uid := PeekUserId([]byte(`{"userId":"dummy.DevUser","version":"x"}`)); uid != "dummy.DevUser" {
+		t.Errorf("PeekUserId = %q, want dummy.DevUser", uid)
+	}
+	if uid := PeekUserId([]byte(`{"userId":`)); uid != "" {
+		t.Errorf("PeekUserId of broken JSON = %q, want \"\"", uid)
+	}
+}
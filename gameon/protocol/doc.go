@@ -0,0 +1,26 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Package protocol encodes and decodes the messages that a Game On!
+// room exchanges with the mediator over its websocket.
+//
+// Every websocket text message is a frame made of a kind, a target
+// and a JSON payload, separated by commas:
+//
+//	roomHello,43a4d07399ea23d648568c6d2d000b65,{"version":1,"username":"DevUser","userId":"dummy.DevUser"}
+//	player,dummy.DevUser,{"type":"event","content":{"dummy.DevUser":"Hello."}}
+//
+// For inbound frames (roomHello, roomGoodbye, roomJoin, roomPart and
+// room) the target is the id of the room being addressed. For
+// outbound frames (player and playerLocation) it is the id of the
+// player the message is meant for, or "*" for everyone. The ack that
+// a room sends when a connection opens has no target at all:
+//
+//	ack,{"version":[1,2]}
+//
+// Encode and Decode check that the fields Game On! requires are
+// present, so a message that Encode accepts can always be decoded
+// and a message that Decode returns can be used without further
+// checking.
+package protocol
@@ -0,0 +1,54 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"fmt"
+)
+
+// A FrameError describes a message that is not a kind,target,payload
+// triple.
+type FrameError struct {
+	Reason string
+}
+
+func (e *FrameError) Error() string { return fmt.Sprintf("protocol: malformed frame: %s", e.Reason) }
+
+// An UnknownKindError describes a frame whose kind we do not know.
+type UnknownKindError struct {
+	Kind string
+}
+
+func (e *UnknownKindError) Error() string { return fmt.Sprintf("protocol: unknown kind '%s'", e.Kind) }
+
+// A PayloadError describes a payload that is not the JSON expected for
+// its kind.
+type PayloadError struct {
+	Kind string
+	Err  error
+}
+
+func (e *PayloadError) Error() string {
+	return fmt.Sprintf("protocol: bad %s payload: %s", e.Kind, e.Err.Error())
+}
+
+// A FieldError describes a required field that is missing or invalid.
+type FieldError struct {
+	Kind   string
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("protocol: %s.%s %s", e.Kind, e.Field, e.Reason)
+}
+
+func missing(kind, field string) error {
+	return &FieldError{kind, field, "is required"}
+}
+
+func invalid(kind, field, reason string) error {
+	return &FieldError{kind, field, reason}
+}
@@ -0,0 +1,61 @@
+//go:build go1.18
+// +build go1.18
+
+// Fuzzing needs Go 1.18; the round trips in codec_test.go run anywhere.
+
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func addSeeds(f *testing.F) {
+	for _, rt := range roundTrips {
+		b, err := Encode(rt.target, rt.m)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Add([]byte("roomHello,room.1,{"))
+	f.Add([]byte(",,"))
+}
+
+func FuzzParseFrame(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		fr, err := ParseFrame(b)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(fr.Bytes(), b) {
+			t.Errorf("ParseFrame(%q).Bytes() = %q", b, fr.Bytes())
+		}
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		target, m, err := Decode(b)
+		if err != nil {
+			return
+		}
+		// Anything we accept must survive being sent back out.
+		b2, err := Encode(target, m)
+		if err != nil {
+			t.Fatalf("Decode(%q) accepted a message that Encode rejects: %v", b, err)
+		}
+		target2, m2, err := Decode(b2)
+		if err != nil {
+			t.Fatalf("Decode(%q) failed after a round trip: %v", b2, err)
+		}
+		// Compare encodings rather than values; an empty map and a
+		// nil map are both left out of the JSON.
+		b3, err := Encode(target2, m2)
+		if err != nil || !bytes.Equal(b3, b2) {
+			t.Errorf("round trip of %q changed %q to %q", b, b2, b3)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+package protocol
+
+// Frame kinds.
+const (
+	// Sent by the room when a connection opens.
+	KindAck = "ack"
+	// Sent by the mediator to the room.
+	KindRoomHello   = "roomHello"
+	KindRoomGoodbye = "roomGoodbye"
+	KindRoomJoin    = "roomJoin"
+	KindRoomPart    = "roomPart"
+	KindRoom        = "room"
+	// Sent by the room to players.
+	KindPlayer         = "player"
+	KindPlayerLocation = "playerLocation"
+)
+
+// Values of the "type" field of the messages sent to players.
+const (
+	TypeEvent    = "event"
+	TypeChat     = "chat"
+	TypeLocation = "location"
+	TypeExit     = "exit"
+)
+
+// A Message is the JSON payload of a frame.
+type Message interface {
+	// Kind returns the kind of frame that carries the message.
+	Kind() string
+	// Validate returns a *FieldError if a field that Game On!
+	// requires is missing or invalid.
+	Validate() error
+}
+
+// Ack lists the protocol versions that a room supports.
+type Ack struct {
+	Version []int `json:"version"`
+}
+
+func (m *Ack) Kind() string { return KindAck }
+
+func (m *Ack) Validate() error {
+	if len(m.Version) == 0 {
+		return missing(KindAck, "version")
+	}
+	for _, v := range m.Version {
+		if v < 1 {
+			return invalid(KindAck, "version", "versions start at 1")
+		}
+	}
+	return nil
+}
+
+// Hello is sent when a player enters the room.
+type Hello struct {
+	Version  int    `json:"version,omitempty"`
+	UserId   string `json:"userId"`
+	Username string `json:"username"`
+}
+
+func (m *Hello) Kind() string { return KindRoomHello }
+
+func (m *Hello) Validate() error {
+	return validatePlayer(KindRoomHello, m.Version, m.UserId, m.Username)
+}
+
+// Join is sent, in protocol version 2, when a player who is already
+// in the room reconnects.
+type Join struct {
+	Version  int    `json:"version,omitempty"`
+	UserId   string `json:"userId"`
+	Username string `json:"username"`
+}
+
+func (m *Join) Kind() string { return KindRoomJoin }
+
+func (m *Join) Validate() error { return validatePlayer(KindRoomJoin, m.Version, m.UserId, m.Username) }
+
+// Goodbye is sent when a player leaves the room.
+type Goodbye struct {
+	UserId   string `json:"userId"`
+	Username string `json:"username"`
+}
+
+func (m *Goodbye) Kind() string { return KindRoomGoodbye }
+
+func (m *Goodbye) Validate() error { return validatePlayer(KindRoomGoodbye, 0, m.UserId, m.Username) }
+
+// Part is sent, in protocol version 2, when a player's connection
+// goes away although they have not left the room.
+type Part struct {
+	UserId   string `json:"userId"`
+	Username string `json:"username"`
+}
+
+func (m *Part) Kind() string { return KindRoomPart }
+
+func (m *Part) Validate() error { return validatePlayer(KindRoomPart, 0, m.UserId, m.Username) }
+
+// Command carries whatever a player typed in the room: either a
+// slash command or something to say.
+type Command struct {
+	UserId   string `json:"userId"`
+	Username string `json:"username"`
+	Content  string `json:"content"`
+}
+
+func (m *Command) Kind() string { return KindRoom }
+
+func (m *Command) Validate() error {
+	if err := validatePlayer(KindRoom, 0, m.UserId, m.Username); err != nil {
+		return err
+	}
+	if m.Content == "" {
+		return missing(KindRoom, "content")
+	}
+	return nil
+}
+
+// Event is text shown to one or more players. Content maps a user id,
+// or "*" for everyone else, to the text that player should see.
+type Event struct {
+	Type     string            `json:"type"`
+	Content  map[string]string `json:"content"`
+	Bookmark int               `json:"bookmark,omitempty"`
+}
+
+// NewEvent returns an event that shows text to a single player.
+func NewEvent(userId, text string) *Event {
+	return &Event{Type: TypeEvent, Content: map[string]string{userId: text}}
+}
+
+func (m *Event) Kind() string { return KindPlayer }
+
+func (m *Event) Validate() error {
+	if m.Type != TypeEvent {
+		return invalid(KindPlayer, "type", "must be "+TypeEvent)
+	}
+	if len(m.Content) == 0 {
+		return missing(KindPlayer, "content")
+	}
+	return nil
+}
+
+// Chat is something said in the room. Username may be empty when a
+// message continues what the previous speaker was saying.
+type Chat struct {
+	Type     string `json:"type"`
+	Username string `json:"username,omitempty"`
+	Content  string `json:"content"`
+	Bookmark int    `json:"bookmark,omitempty"`
+}
+
+// NewChat returns a chat message said by username.
+func NewChat(username, content string) *Chat {
+	return &Chat{Type: TypeChat, Username: username, Content: content}
+}
+
+func (m *Chat) Kind() string { return KindPlayer }
+
+func (m *Chat) Validate() error {
+	if m.Type != TypeChat {
+		return invalid(KindPlayer, "type", "must be "+TypeChat)
+	}
+	if m.Content == "" {
+		return missing(KindPlayer, "content")
+	}
+	return nil
+}
+
+// Location describes the room to a player who has just entered it.
+type Location struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	FullName    string `json:"fullName,omitempty"`
+	Description string `json:"description"`
+	// Commands that the room adds to Game On!'s minimal set, mapped
+	// to the descriptions that /help should show for them.
+	Commands map[string]string `json:"commands,omitempty"`
+}
+
+// NewLocation returns a location response for the named room.
+func NewLocation(name, fullName, description string) *Location {
+	return &Location{Type: TypeLocation, Name: name, FullName: fullName, Description: description}
+}
+
+func (m *Location) Kind() string { return KindPlayer }
+
+func (m *Location) Validate() error {
+	if m.Type != TypeLocation {
+		return invalid(KindPlayer, "type", "must be "+TypeLocation)
+	}
+	if m.Name == "" {
+		return missing(KindPlayer, "name")
+	}
+	if m.Description == "" {
+		return missing(KindPlayer, "description")
+	}
+	return nil
+}
+
+// Exit moves a player out of the room through one of its doors.
+type Exit struct {
+	Type    string `json:"type"`
+	ExitId  string `json:"exitId"`
+	Content string `json:"content,omitempty"`
+}
+
+// NewExit returns a message that moves a player through exitId.
+func NewExit(exitId, content string) *Exit {
+	return &Exit{Type: TypeExit, ExitId: exitId, Content: content}
+}
+
+func (m *Exit) Kind() string { return KindPlayerLocation }
+
+func (m *Exit) Validate() error {
+	if m.Type != TypeExit {
+		return invalid(KindPlayerLocation, "type", "must be "+TypeExit)
+	}
+	if m.ExitId == "" {
+		return missing(KindPlayerLocation, "exitId")
+	}
+	return nil
+}
+
+func validatePlayer(kind string, version int, userId, username string) error {
+	if version < 0 {
+		return invalid(kind, "version", "must not be negative")
+	}
+	if userId == "" {
+		return missing(kind, "userId")
+	}
+	if username == "" {
+		return missing(kind, "username")
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Verification of the websocket handshake sent by the mediator
+package main
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// When the Game On! mediator opens a websocket to our room it signs
+// the upgrade request: gameon-date carries an RFC1123 timestamp and
+// gameon-signature carries an HMAC of that timestamp made with our
+// shared secret, or with our connection token if we registered one
+// (see token.go). We recompute the HMAC and insist that the date is
+// reasonably close to our own clock.
+//
+// Once a connection has been upgraded we refuse its signature and
+// date while the date is still within the skew window, so that a
+// captured handshake cannot be used to open another connection. Only
+// what the signature covers can tell one handshake from another; the
+// Sec-WebSocket-Key, say, is the client's to choose. The date has a
+// resolution of one second, so a second connection signed within the
+// same second as the first is refused too, and must be signed again.
+
+// A replayCache remembers the handshakes of recent connections until
+// the dates they were made for fall outside the skew window.
+type replayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+var handshakeReplays = replayCache{seen: make(map[string]time.Time)}
+
+// Reports whether key has been recorded and has not yet expired at now.
+func (c *replayCache) seenBefore(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, exp := range c.seen {
+		if now.After(exp) {
+			delete(c.seen, k)
+		}
+	}
+	_, ok := c.seen[key]
+	return ok
+}
+
+// Records key as seen until expires.
+func (c *replayCache) record(key string, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen[key] = expires
+}
+
+// Returns the key under which the handshake of r is remembered.
+func replayKey(r *http.Request) string {
+	return r.Header.Get("gameon-signature") + " " + r.Header.Get("gameon-date")
+}
+
+// Returns the gameon-date of r.
+func handshakeDate(r *http.Request) (time.Time, error) {
+	date := r.Header.Get("gameon-date")
+	ts, err := http.ParseTime(date)
+	if err != nil {
+		ts, err = time.Parse(time.RFC1123, date)
+	}
+	if err != nil {
+		return ts, HandshakeError{fmt.Sprintf("Unparseable gameon-date '%s'", date)}
+	}
+	return ts, nil
+}
+
+// Checks the signature and date on an incoming websocket upgrade
+// request. Returns nil if the request was signed by someone holding
+// our token or shared secret, or the secret that was rotated out within
+// the last -secretGrace seconds, otherwise a HandshakeError describing
+// the problem.
+func verifyHandshake(r *http.Request) error {
+	sig := r.Header.Get("gameon-signature")
+	date := r.Header.Get("gameon-date")
+	if sig == "" || date == "" {
+		return HandshakeError{"Missing gameon-signature or gameon-date header."}
+	}
+	ts, err := handshakeDate(r)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	window := time.Duration(config.handshakeSkew) * time.Second
+	skew := now.Sub(ts)
+	if skew < -window || skew > window {
+		return HandshakeError{fmt.Sprintf("gameon-date is %v away from our clock", skew)}
+	}
+
+	matched := false
+	for _, key := range handshakeKeys(now) {
+		expected := buildHmac([]string{date}, key)
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return HandshakeError{"Signature mismatch."}
+	}
+	if handshakeReplays.seenBefore(replayKey(r), now) {
+		return HandshakeError{"Signature has already been used."}
+	}
+	return nil
+}
+
+// Records the handshake of r, which verifyHandshake accepted, once its
+// connection has been upgraded.
+func recordHandshake(r *http.Request) {
+	ts, err := handshakeDate(r)
+	if err != nil {
+		return
+	}
+	handshakeReplays.record(replayKey(r), ts.Add(time.Duration(config.handshakeSkew)*time.Second))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Forgets the handshakes of earlier tests, which may have been signed
+// with the same secret in the same second.
+func forgetHandshakes() {
+	handshakeReplays.mu.Lock()
+	handshakeReplays.seen = make(map[string]time.Time)
+	handshakeReplays.mu.Unlock()
+}
+
+func TestVerifyHandshake(t *testing.T) {
+	config.secret = "handshake-test-secret"
+	config.handshakeSkew = 60
+	forgetHandshakes()
+
+	date := time.Now().UTC().Format(time.RFC1123)
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("gameon-date", date)
+	req.Header.Set("gameon-signature", buildHmac([]string{date}, config.secret))
+	req.Header.Set("Sec-WebSocket-Key", "first")
+
+	if err := verifyHandshake(req); err != nil {
+		t.Fatalf("A correctly signed handshake was rejected: %v", err)
+	}
+	if err := verifyHandshake(req); err != nil {
+		t.Errorf("A handshake whose upgrade failed could not be tried again: %v", err)
+	}
+	recordHandshake(req)
+	if err := verifyHandshake(req); err == nil {
+		t.Errorf("A replayed handshake was accepted.")
+	}
+	// The key is not signed, so a replay may carry a fresh one.
+	req.Header.Set("Sec-WebSocket-Key", "second")
+	if err := verifyHandshake(req); err == nil {
+		t.Errorf("A replayed handshake with a new Sec-WebSocket-Key was accepted.")
+	}
+
+	req.Header.Set("gameon-signature", buildHmac([]string{date}, "some-other-secret"))
+	if err := verifyHandshake(req); err == nil {
+		t.Errorf("A handshake signed with the wrong secret was accepted.")
+	}
+
+	old := time.Now().Add(-5 * time.Minute).UTC().Format(time.RFC1123)
+	req.Header.Set("gameon-date", old)
+	req.Header.Set("gameon-signature", buildHmac([]string{old}, config.secret))
+	if err := verifyHandshake(req); err == nil {
+		t.Errorf("A handshake outside the skew window was accepted.")
+	}
+
+	config.token = "handshake-test-token"
+	defer func() { config.token = "" }()
+	date = time.Now().UTC().Format(time.RFC1123)
+	req.Header.Set("gameon-date", date)
+	req.Header.Set("gameon-signature", buildHmac([]string{date}, config.secret))
+	if err := verifyHandshake(req); err == nil {
+		t.Errorf("A handshake signed with the secret was accepted although we registered a token.")
+	}
+	req.Header.Set("gameon-signature", buildHmac([]string{date}, config.token))
+	if err := verifyHandshake(req); err != nil {
+		t.Errorf("A handshake signed with our token was rejected: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Room manifests
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strings"
+)
+
+// Without a manifest we serve the one room described by -r, -north,
+// -south and the other door flags. With -manifest we serve every room
+// that the manifest lists instead. A manifest is YAML (or JSON, which
+// YAML accepts) such as:
+//
+//   rooms:
+//   - name: ROOM.1
+//     fullName: The Dusty Library
+//     description: Shelves of books line every wall.
+//     doors:
+//       north: A frost-covered door leads to the south.
+//       east: A badly-painted door opens to the west.
+//     commands:
+//     - name: look
+//     - name: examine
+//     - name: go
+//     - name: read
+//       description: Read a book.
+//       response: The pages are blank. All of them.
+//
+// Doors are described as they are by the door flags. A room that
+// lists no commands gets the standard set; otherwise it gets exactly
+// the ones listed. A command with a response is a new command that
+// always answers with that response; one without must name a standard
+// command (doors, examine, go, inventory, look or wink).
+
+// A roomSpec describes one room that we register and serve.
+type roomSpec struct {
+	Name        string        `yaml:"name"`
+	FullName    string        `yaml:"fullName"`
+	Description string        `yaml:"description"`
+	Doors       doorSpec      `yaml:"doors"`
+	Commands    []commandSpec `yaml:"commands"`
+}
+
+// Describes the outside of each of a room's doors.
+type doorSpec struct {
+	North string `yaml:"north"`
+	South string `yaml:"south"`
+	East  string `yaml:"east"`
+	West  string `yaml:"west"`
+	Up    string `yaml:"up"`
+	Down  string `yaml:"down"`
+}
+
+type commandSpec struct {
+	// The command word, with or without its slash.
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Response    string `yaml:"response"`
+}
+
+type manifest struct {
+	Rooms []roomSpec `yaml:"rooms"`
+}
+
+// The rooms that we register, from -manifest or from the flags.
+var roomSpecs []*roomSpec
+
+// Returns the room described by the commandline flags.
+func flagRoomSpec() *roomSpec {
+	return &roomSpec{
+		Name:     config.roomName,
+		FullName: config.roomName,
+		Doors: doorSpec{
+			North: config.north,
+			South: config.south,
+			East:  config.east,
+			West:  config.west,
+			Up:    config.up,
+			Down:  config.down,
+		},
+	}
+}
+
+// Reads and checks the manifest at path.
+func loadManifest(path string) (specs []*roomSpec, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var m manifest
+	err = yaml.UnmarshalStrict(b, &m)
+	if err != nil {
+		err = ArgError{fmt.Sprintf("Manifest %s: %s", path, err.Error())}
+		return
+	}
+	if len(m.Rooms) == 0 {
+		err = ArgError{fmt.Sprintf("Manifest %s lists no rooms.", path)}
+		return
+	}
+	seen := make(map[string]bool)
+	for i := range m.Rooms {
+		r := &m.Rooms[i]
+		if len(r.Name) == 0 {
+			err = ArgError{fmt.Sprintf("Manifest %s: room %d has no name.", path, i+1)}
+			return
+		}
+		if seen[r.Name] {
+			err = ArgError{fmt.Sprintf("Manifest %s: room %s is listed twice.", path, r.Name)}
+			return
+		}
+		seen[r.Name] = true
+		if len(r.FullName) == 0 {
+			r.FullName = r.Name
+		}
+		for _, c := range r.Commands {
+			word := commandWord(c.Name)
+			if len(word) == 0 {
+				err = ArgError{fmt.Sprintf("Manifest %s: room %s has a command with no name.", path, r.Name)}
+				return
+			}
+			if len(c.Response) == 0 && standardCommands[word] == nil {
+				err = ArgError{fmt.Sprintf("Manifest %s: room %s: '%s' is not a standard command and has no response.",
+					path, r.Name, c.Name)}
+				return
+			}
+		}
+		specs = append(specs, r)
+	}
+	return
+}
+
+// Returns the spec for the room with the given name, or nil.
+func findRoomSpec(name string) *roomSpec {
+	for _, s := range roomSpecs {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Returns the upper-case command word for a command name such as
+// "/Look" or "look".
+func commandWord(name string) string {
+	return strings.ToUpper(strings.TrimPrefix(strings.TrimSpace(name), "/"))
+}
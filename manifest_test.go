@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	load := func(yaml string) ([]*roomSpec, error) {
+		path := filepath.Join(dir, "rooms.yaml")
+		if err := ioutil.WriteFile(path, []byte(yaml), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return loadManifest(path)
+	}
+
+	specs, err := load(`
+rooms:
+- name: ROOM.1
+  fullName: The Dusty Library
+  commands:
+  - name: /look
+  - name: read
+    description: Read a book.
+    response: The pages are blank.
+- name: ROOM.2
+`)
+	if err != nil {
+		t.Fatalf("loadManifest failed: %v", err)
+	}
+	if len(specs) != 2 || specs[1].FullName != "ROOM.2" {
+		t.Fatalf("Loaded %+v", specs)
+	}
+	r := newRoomFromSpec("site.1", specs[0])
+	if words := strings.Join(r.commandWords(), " "); words != "LOOK READ" {
+		t.Errorf("The room has commands %s", words)
+	}
+	if len(r.commandsWeAdd) != 1 || r.commandsWeAdd[0].cmd != "/read" {
+		t.Errorf("The room describes %+v", r.commandsWeAdd)
+	}
+	if len(newRoomFromSpec("site.2", specs[1]).commands) != len(standardCommands) {
+		t.Errorf("A room listing no commands did not get the standard set.")
+	}
+
+	for what, yaml := range map[string]string{
+		"no rooms":           "rooms: []",
+		"a nameless room":    "rooms:\n- fullName: Nowhere",
+		"a room twice":       "rooms:\n- name: A\n- name: A",
+		"a missing handler":  "rooms:\n- name: A\n  commands:\n  - name: dance",
+		"a nameless command": "rooms:\n- name: A\n  commands:\n  - description: Nothing.",
+		"an unknown field":   "rooms:\n- name: A\n  colour: red",
+	} {
+		if _, err := load(yaml); err == nil {
+			t.Errorf("A manifest with %s was accepted.", what)
+		}
+	}
+}
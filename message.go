@@ -6,45 +6,34 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/gorilla/websocket"
+	"sample-room-golang/gameon/protocol"
+	"sync/atomic"
 )
 
-type PlayerMessage struct {
-	Rtype    string            `json:"type,omitempty"`
-	Content  map[string]string `json:"content,omitempty"`
-	Bookmark int               `json:"bookmark,omitempty"`
-}
-
-var bookmark = 1
+// The last bookmark we sent. Every read loop sends events, so only
+// access with sync/atomic.
+var bookmark int64
 
 // Sends an event message to a player using the current websocket.
-func SendMessageToPlayer(conn *websocket.Conn, mUser, uid string) (e error) {
-	var msg PlayerMessage
-	var j []byte
-	msg.Rtype = "event"
-	msg.Bookmark = bookmark
-	bookmark += 1
-	msg.Content = make(map[string]string)
-
-	msg.Content[uid] = mUser
-
-	j, e = json.MarshalIndent(msg, "", "    ")
-	if e != nil {
-		return
-	}
-	e = SendMessage(conn, uid, j, MTPlayer)
+func SendMessageToPlayer(sess *Session, mUser, uid string) (e error) {
+	msg := protocol.NewEvent(uid, mUser)
+	msg.Bookmark = int(atomic.AddInt64(&bookmark, 1))
+	e = SendMessage(sess, uid, msg)
 	return
 }
 
-// Sends a message with a JSON payload.
-func SendMessage(conn *websocket.Conn, targetid string, j []byte, messageType string) (e error) {
+// Encodes m and queues it for delivery to targetid.
+func SendMessage(sess *Session, targetid string, m protocol.Message) (e error) {
 	locus := "SEND.MSG"
-	var m = fmt.Sprintf("%s,%s,%s", messageType, targetid, string(j))
-	e = conn.WriteMessage(ExpectedMessageType, []byte(m))
+	f, e := protocol.NewFrame(targetid, m)
+	if e != nil {
+		checkpoint(locus, fmt.Sprintf("ENCODE.FAILED err=%s", e.Error()))
+		return
+	}
+	e = sess.Send(f.Bytes())
 	if config.debug {
-		checkpoint(locus, fmt.Sprintf("m=%s", m))
+		checkpoint(locus, fmt.Sprintf("cmd=%s target=%s json=%s", f.Kind, f.Target, f.Payload))
 	}
 	if e != nil {
 		checkpoint(locus, fmt.Sprintf("FAILED err=%s", e.Error()))
@@ -0,0 +1,90 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Room metrics
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics about the room itself, as opposed to the HTTP requests
+// counted by RequestTracker. All of them are served from /metrics.
+var (
+	rateLimitedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "room",
+		Subsystem: "ratelimit",
+		Name:      "refused_count",
+		Help:      "Number of player requests refused by the rate limiter",
+	}, []string{"Class"})
+	mutedCount = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "room",
+		Subsystem: "ratelimit",
+		Name:      "muted_count",
+		Help:      "Number of times a player has been muted for flooding",
+	})
+	registrationChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "room",
+		Subsystem: "registration",
+		Name:      "check_count",
+		Help:      "Number of registration checks, by result",
+	}, []string{"Result"})
+	registrationRepairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "room",
+		Subsystem: "registration",
+		Name:      "repair_count",
+		Help:      "Number of registrations the watchdog has re-created or updated",
+	}, []string{"Action"})
+	registrationHealthy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "room",
+		Subsystem: "registration",
+		Name:      "healthy",
+		Help:      "1 if all of our rooms are registered as we expect, otherwise 0",
+	})
+	registrationLastCheck = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "room",
+		Subsystem: "registration",
+		Name:      "last_check_timestamp_seconds",
+		Help:      "When our registrations were last checked, in seconds since the epoch",
+	})
+	clockSkewSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "room",
+		Subsystem: "clock",
+		Name:      "skew_seconds",
+		Help:      "How far the map service's clock is ahead of ours, as measured from its responses",
+	})
+	secretRotations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "room",
+		Subsystem: "secret",
+		Name:      "rotation_count",
+		Help:      "Number of times a new shared secret has been read from -secretFile",
+	})
+	accessDenied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "room",
+		Subsystem: "access",
+		Name:      "denied_count",
+		Help:      "Number of requests refused by an allowlist, by policy",
+	}, []string{"Policy"})
+	certExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "room",
+		Subsystem: "tls",
+		Name:      "cert_expiry_timestamp_seconds",
+		Help:      "When the certificate we serve expires, in seconds since the epoch",
+	})
+)
+
+// Registers the room's metrics with Prometheus. This must be called
+// once, before /metrics is served.
+func registerMetrics() {
+	prometheus.MustRegister(rateLimitedCount)
+	prometheus.MustRegister(mutedCount)
+	prometheus.MustRegister(registrationChecks)
+	prometheus.MustRegister(registrationRepairs)
+	prometheus.MustRegister(registrationHealthy)
+	prometheus.MustRegister(registrationLastCheck)
+	prometheus.MustRegister(clockSkewSeconds)
+	prometheus.MustRegister(secretRotations)
+	prometheus.MustRegister(certExpiry)
+	prometheus.MustRegister(accessDenied)
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Per-player rate limiting
+package main
+
+import (
+	"fmt"
+	"sample-room-golang/gameon/protocol"
+	"sync"
+	"time"
+)
+
+// Every chat and slash command costs the player one token from a
+// bucket that refills at a steady rate. Chat, ordinary commands and
+// expensive commands (those that answer at length, such as /look)
+// have separate buckets, each sized by its own -xxxRate and -xxxBurst
+// flags. A player whose bucket is empty is warned once and their
+// requests are dropped until it refills. If -muteAfter is set, a
+// player who keeps going regardless is muted for -muteSeconds.
+
+// Classes of request, each with its own bucket.
+const (
+	LimitChat      = "chat"
+	LimitCommand   = "command"
+	LimitExpensive = "expensive"
+)
+
+// Commands that are limited as LimitExpensive rather than
+// LimitCommand.
+var expensiveCommands = map[string]bool{
+	slashInventory: true,
+	slashLook:      true,
+}
+
+// Returns the class of request that the slash command cmd belongs to.
+func commandLimitClass(cmd string) string {
+	if expensiveCommands[cmd] {
+		return LimitExpensive
+	}
+	return LimitCommand
+}
+
+// A tokenBucket holds up to burst tokens and gains rate tokens per
+// second.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Takes a token if there is one.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens -= 1
+	return true
+}
+
+// What we remember about one player in one room.
+type playerLimits struct {
+	buckets map[string]*tokenBucket
+	// The number of requests refused since the last one we allowed.
+	strikes    int
+	mutedUntil time.Time
+	lastSeen   time.Time
+}
+
+type RateLimiter struct {
+	mu      sync.Mutex
+	players map[string]*playerLimits
+	// When we last looked for players to forget.
+	pruned time.Time
+}
+
+// Players who have made no request for this long, and are not muted,
+// are forgotten; by then their buckets are full again anyway.
+const rateLimitIdle = 10 * time.Minute
+
+var rateLimiter = RateLimiter{players: make(map[string]*playerLimits)}
+
+// The outcome of a call to RateLimiter.allow.
+type limitVerdict int
+
+const (
+	limitAllow limitVerdict = iota
+	// Refused; the player should be told.
+	limitWarn
+	// Refused; the player has already been told.
+	limitDrop
+	// Refused, and the player has just been muted.
+	limitMute
+)
+
+// Returns the configured rate and burst for class. A rate of zero
+// means that class is not limited.
+func limitFor(class string) (rate float64, burst int) {
+	switch class {
+	case LimitChat:
+		return config.chatRate, config.chatBurst
+	case LimitExpensive:
+		return config.expensiveRate, config.expensiveBurst
+	default:
+		return config.commandRate, config.commandBurst
+	}
+}
+
+// Decides whether the player identified by key may make a request of
+// the given class at time now.
+func (rl *RateLimiter) allow(key, class string, now time.Time) limitVerdict {
+	rate, burst := limitFor(class)
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if now.Sub(rl.pruned) > time.Minute {
+		rl.prune(now.Add(-rateLimitIdle))
+		rl.pruned = now
+	}
+	pl := rl.players[key]
+	if pl == nil {
+		pl = &playerLimits{buckets: make(map[string]*tokenBucket)}
+		rl.players[key] = pl
+	}
+	pl.lastSeen = now
+	if now.Before(pl.mutedUntil) {
+		return limitDrop
+	}
+	if rate <= 0 {
+		pl.strikes = 0
+		return limitAllow
+	}
+	b := pl.buckets[class]
+	if b == nil {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		pl.buckets[class] = b
+	}
+	if b.take(now, rate, burst) {
+		pl.strikes = 0
+		return limitAllow
+	}
+	pl.strikes += 1
+	if config.muteAfter > 0 && pl.strikes >= config.muteAfter {
+		pl.strikes = 0
+		pl.mutedUntil = now.Add(time.Duration(config.muteSeconds) * time.Second)
+		return limitMute
+	}
+	if pl.strikes == 1 {
+		return limitWarn
+	}
+	return limitDrop
+}
+
+// Forgets players who have not made a request since before horizon
+// and are not muted. rl.mu must be held.
+func (rl *RateLimiter) prune(horizon time.Time) {
+	for k, pl := range rl.players {
+		if pl.lastSeen.Before(horizon) && horizon.After(pl.mutedUntil) {
+			delete(rl.players, k)
+		}
+	}
+}
+
+// Reports whether the player who sent req may make a request of the
+// given class, telling them politely if they may not. Refusals are
+// counted in the rate limit metrics.
+func admitRequest(sess *Session, req *protocol.Command, room *Room, class string) bool {
+	locus := "RATE.LIMIT"
+	verdict := rateLimiter.allow(makePlayerKey(req.UserId, room.id), class, time.Now())
+	if verdict == limitAllow {
+		return true
+	}
+	rateLimitedCount.WithLabelValues(class).Inc()
+	if config.debug {
+		checkpoint(locus, fmt.Sprintf("REFUSED class=%s userId=%s", class, req.UserId))
+	}
+	switch verdict {
+	case limitWarn:
+		SendMessageToPlayer(sess,
+			"Whoa, slow down! Give the room a moment to catch up.", req.UserId)
+	case limitMute:
+		mutedCount.Inc()
+		checkpoint(locus, fmt.Sprintf("MUTED userId=%s seconds=%d", req.UserId, config.muteSeconds))
+		SendMessageToPlayer(sess, fmt.Sprintf(
+			"You have been muted for %d seconds. Please take a breather.",
+			config.muteSeconds), req.UserId)
+	}
+	return false
+}
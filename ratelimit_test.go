@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sample-room-golang/gameon/protocol"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitRefillAndMute(t *testing.T) {
+	config.commandRate, config.commandBurst = 1, 2
+	config.chatRate, config.chatBurst = 1, 1
+	config.muteAfter, config.muteSeconds = 3, 5
+	defer func() { config.commandRate, config.chatRate, config.muteAfter = 0, 0, 0 }()
+	rl := RateLimiter{players: make(map[string]*playerLimits)}
+	t0 := time.Now()
+	at := func(d time.Duration) time.Time { return t0.Add(d) }
+
+	for i, want := range []limitVerdict{limitAllow, limitAllow, limitWarn, limitDrop} {
+		if got := rl.allow("p", LimitCommand, t0); got != want {
+			t.Errorf("Command %d: got %v, want %v", i+1, got, want)
+		}
+	}
+	// Each class has its own bucket.
+	if got := rl.allow("p", LimitChat, t0); got != limitAllow {
+		t.Errorf("Chat with an empty command bucket: got %v", got)
+	}
+	// A second later one token has come back.
+	if got := rl.allow("p", LimitCommand, at(time.Second)); got != limitAllow {
+		t.Errorf("After a refill: got %v", got)
+	}
+	for i, want := range []limitVerdict{limitWarn, limitDrop, limitMute} {
+		if got := rl.allow("p", LimitCommand, at(time.Second)); got != want {
+			t.Errorf("Flood %d: got %v, want %v", i+1, got, want)
+		}
+	}
+	// Muted players are refused even a full bucket, until the mute
+	// expires.
+	if got := rl.allow("p", LimitChat, at(5*time.Second)); got != limitDrop {
+		t.Errorf("While muted: got %v", got)
+	}
+	if got := rl.allow("p", LimitCommand, at(7*time.Second)); got != limitAllow {
+		t.Errorf("After the mute: got %v", got)
+	}
+	if got := rl.allow("q", LimitCommand, at(7*time.Second)); got != limitAllow {
+		t.Errorf("Another player was limited: got %v", got)
+	}
+}
+
+func TestUnparseableCommandsMute(t *testing.T) {
+	config.commandRate, config.commandBurst = 1, 2
+	config.muteAfter, config.muteSeconds = 3, 60
+	defer func() { config.commandRate, config.muteAfter = 0, 0 }()
+	const uid = "dummy.Garbler"
+	room := newRoom("garble.room", "garble", "The Garbling Room")
+	defer func() {
+		rateLimiter.mu.Lock()
+		delete(rateLimiter.players, makePlayerKey(uid, room.id))
+		rateLimiter.mu.Unlock()
+	}()
+	sess := queueSession(64)
+	req := &protocol.Command{UserId: uid, Content: "/xyz"}
+
+	var replies []string
+	for i := 0; i < 10; i++ {
+		handleSlashCommand(sess, req, room)
+		replies = append(replies, queued(sess)...)
+	}
+	// Two answers from the burst, a warning, and the mute.
+	if len(replies) != 4 || !strings.Contains(replies[3], "muted") {
+		t.Errorf("A burst of unparseable commands was answered with %q", replies)
+	}
+	req.Content = "/look"
+	handleSlashCommand(sess, req, room)
+	if got := queued(sess); len(got) != 0 {
+		t.Errorf("A muted player was answered with %q", got)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Redaction of personal data and credentials from our log
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Our log is full of user ids, usernames, what players say and the
+// raw JSON that the mediator sends. Everything we log goes through a
+// redactor, a logrus formatter placed in front of the real one, which
+// finds the fields it knows about, whether written as name=value
+// (value running to the next space or comma, or quoted as %q quotes
+// it), as a JSON member "name":"value" or "name":{...}, or as logrus
+// fields, and treats each according to its rule:
+//
+//   keep   leaves the value alone.
+//   hash   replaces it with a keyed hash, so that one player's lines
+//          can still be told apart from another's. The key is made up
+//          afresh each time we start.
+//   mask   replaces it with ***, unless -verbose is given.
+//   strip  replaces it with [stripped], always. For credentials.
+//
+// Field names are not case sensitive. -redact changes the rules, for
+// example -redact username=keep,content=hash.
+
+// What a rule does with a value.
+const (
+	redactKeep  = "keep"
+	redactHash  = "hash"
+	redactMask  = "mask"
+	redactStrip = "strip"
+)
+
+// The rules we start with.
+var defaultRedaction = map[string]string{
+	"userid":           redactHash,
+	"playerid":         redactHash,
+	"playerkey":        redactHash,
+	"username":         redactHash,
+	"sender":           redactHash,
+	"receiver":         redactHash,
+	"target":           redactHash,
+	"content":          redactMask,
+	"message":          redactMask,
+	"tail":             redactMask,
+	"token":            redactStrip,
+	"gameon-signature": redactStrip,
+	"gameon-sig-body":  redactStrip,
+}
+
+// Values that name no one and are never hashed: no one, and everyone.
+var anonymous = map[string]bool{"": true, "*": true}
+
+// A redactor is a logrus.Formatter that redacts each entry before
+// handing it to next.
+type redactor struct {
+	next log.Formatter
+
+	mu      sync.RWMutex
+	rules   map[string]string
+	verbose bool
+	key     []byte
+	// Match name=value and "name":"value" for the names in rules.
+	pairs   *regexp.Regexp
+	members *regexp.Regexp
+}
+
+var redaction *redactor
+
+// Returns a redactor, with the default rules, in front of next.
+func newRedactor(next log.Formatter) *redactor {
+	r := &redactor{next: next, key: make([]byte, 16)}
+	rand.Read(r.key)
+	r.setRules(defaultRedaction, false)
+	return r
+}
+
+// Replaces the rules.
+func (r *redactor) setRules(rules map[string]string, verbose bool) {
+	var names []string
+	for name := range rules {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	// Longest first, so that a name is not taken for its own prefix.
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	alt := strings.Join(names, "|")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules, r.verbose = rules, verbose
+	r.pairs = regexp.MustCompile(`(?i)(^|[^\w-])(` + alt + `)=("(?:[^"\\]|\\.)*"|[^\s,]*)`)
+	r.members = regexp.MustCompile(`(?i)"(` + alt + `)"(\s*:\s*)("(?:[^"\\]|\\.)*"|\{[^{}]*\})`)
+}
+
+// Parses -redact, a comma-separated list of name=rule, into the
+// default rules with those changes.
+func parseRedaction(list string) (map[string]string, error) {
+	rules := make(map[string]string)
+	for name, rule := range defaultRedaction {
+		rules[name] = rule
+	}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return nil, ArgError{fmt.Sprintf("'%s' is not name=rule.", s)}
+		}
+		switch kv[1] {
+		case redactKeep, redactHash, redactMask, redactStrip:
+		default:
+			return nil, ArgError{fmt.Sprintf("Unknown redaction rule '%s'; use keep, hash, mask or strip.", kv[1])}
+		}
+		rules[strings.ToLower(kv[0])] = kv[1]
+	}
+	return rules, nil
+}
+
+// Applies -redact and -verbose to our redactor.
+func loadRedaction() error {
+	rules, err := parseRedaction(config.redact)
+	if err != nil {
+		return err
+	}
+	if redaction != nil {
+		redaction.setRules(rules, config.verbose)
+	}
+	return nil
+}
+
+// Returns what the rule for name makes of value.
+func (r *redactor) redact(name, value string) string {
+	switch r.rules[strings.ToLower(name)] {
+	case redactHash:
+		if anonymous[value] {
+			return value
+		}
+		h := hmac.New(sha256.New, r.key)
+		h.Write([]byte(value))
+		return "#" + hex.EncodeToString(h.Sum(nil))[:12]
+	case redactMask:
+		if r.verbose {
+			return value
+		}
+		return "***"
+	case redactStrip:
+		return "[stripped]"
+	}
+	return value
+}
+
+// Returns s with every field it knows about redacted.
+func (r *redactor) redactText(s string) string {
+	s = r.members.ReplaceAllStringFunc(s, func(m string) string {
+		g := r.members.FindStringSubmatch(m)
+		// The value is a string or, as the content of an event is, a
+		// flat object.
+		value := g[3]
+		if strings.HasPrefix(value, `"`) {
+			if u, err := strconv.Unquote(value); err == nil {
+				value = u
+			}
+		}
+		v := r.redact(g[1], value)
+		if v == value {
+			return m
+		}
+		return `"` + g[1] + `"` + g[2] + strconv.Quote(v)
+	})
+	return r.pairs.ReplaceAllStringFunc(s, func(m string) string {
+		g := r.pairs.FindStringSubmatch(m)
+		value, quoted := g[3], false
+		if strings.HasPrefix(value, `"`) {
+			if u, err := strconv.Unquote(value); err == nil {
+				value, quoted = u, true
+			}
+		}
+		v := r.redact(g[2], value)
+		if v == value {
+			return m
+		}
+		if quoted {
+			v = strconv.Quote(v)
+		}
+		return g[1] + g[2] + "=" + v
+	})
+}
+
+// Format redacts the entry's message and fields, then formats it with
+// the next formatter.
+func (r *redactor) Format(e *log.Entry) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c := *e
+	c.Message = r.redactText(e.Message)
+	if len(e.Data) > 0 {
+		c.Data = make(log.Fields, len(e.Data))
+		for k, v := range e.Data {
+			if s, ok := v.(string); ok {
+				if _, known := r.rules[strings.ToLower(k)]; known {
+					v = r.redact(k, s)
+				} else {
+					v = r.redactText(s)
+				}
+			}
+			c.Data[k] = v
+		}
+	}
+	return r.next.Format(&c)
+}
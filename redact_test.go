@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	r := newRedactor(&log.TextFormatter{DisableTimestamp: true})
+	format := func(msg string, fields log.Fields) string {
+		b, err := r.Format(&log.Entry{Message: msg, Data: fields})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	secrets := []string{"dummy.Alice", "Alice Liddell", "meet me at noon", "c2lnbmF0dXJl"}
+	leaks := func(s string) {
+		for _, secret := range secrets {
+			if strings.Contains(s, secret) {
+				t.Errorf("%q leaks %q", s, secret)
+			}
+		}
+	}
+
+	hello := format(`CHECKPOINT: ROOM.HANDLER.roomHello,ROOM.1,{"userId":"dummy.Alice","username":"Alice Liddell","version":2}`, nil)
+	leaks(hello)
+	if !strings.Contains(hello, "ROOM.1") || !strings.Contains(hello, `version`) {
+		t.Errorf("Redacted too much: %q", hello)
+	}
+	leaks(format(`CHECKPOINT: HELLO.room=ROOM.1 version=2 userid=dummy.Alice username="Alice Liddell"`, nil))
+	leaks(format(`CHECKPOINT: ROOM.CHAT.cmd=chat tail="meet me at noon"`, nil))
+	leaks(format(`CHECKPOINT: SEND.MSG.cmd=player target=dummy.Alice json={"type":"event","content":{"dummy.Alice":"meet me at noon"}}`, nil))
+	leaks(format(`gameon-signature=c2lnbmF0dXJl`, log.Fields{"userId": "dummy.Alice", "note": "username=dummy.Alice"}))
+
+	// The same id always hashes the same way, so that a player can be
+	// followed through the log, and everyone stays everyone.
+	a := format("userid=dummy.Alice", nil)
+	if a != format("userid=dummy.Alice", nil) || a == format("userid=dummy.Bob", nil) {
+		t.Errorf("Hashes are not consistent")
+	}
+	if s := format("receiver=*", nil); !strings.Contains(s, "receiver=*") {
+		t.Errorf("Redacted everyone: %q", s)
+	}
+
+	rules, err := parseRedaction("username=keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.setRules(rules, true)
+	s := format(`{"username":"Alice Liddell","content":"meet me at noon","userId":"dummy.Alice"}`, nil)
+	if !strings.Contains(s, "Alice Liddell") || !strings.Contains(s, "meet me at noon") || strings.Contains(s, "dummy.Alice") {
+		t.Errorf("With username=keep and -verbose got %q", s)
+	}
+	if _, err := parseRedaction("username=scramble"); err == nil {
+		t.Errorf("An unknown rule was accepted.")
+	}
+
+	// The JSON we log stays JSON.
+	var buf bytes.Buffer
+	r = newRedactor(&log.JSONFormatter{})
+	logger := log.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(r)
+	logger.Printf(`payload={"userId":"dummy.Alice","content":"meet me at noon"}`)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Errorf("Not JSON: %q", buf.String())
+	}
+	leaks(buf.String())
+}
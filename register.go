@@ -6,12 +6,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	log "github.com/sirupsen/logrus"
-	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"sample-room-golang/gameon/mapclient"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,342 +25,402 @@ const (
 	MaxRegQueries = 2048
 )
 
-type ConnDetails struct {
-	Type   string `json:"type,omitempty"`
-	Target string `json:"target,omitempty"`
-}
-
-type DoorGroup struct {
-	North string `json:"n,omitempty"`
-	South string `json:"s,omitempty"`
-	East  string `json:"e,omitempty"`
-	West  string `json:"w,omitempty"`
-	// Up is allowed, but is currently ignored.
-	Up string `json:"u,omitempty"`
-	// Down is allowed, but is currently ignored
-	Down string `json:"d,omitempty"`
-}
-
-type RoomRegistrationReq struct {
-	Name              string      `json:"name,omitempty"`
-	FullName          string      `json:"fullName,omitempty"`
-	ConnectionDetails ConnDetails `json:"connectionDetails,omitempty"`
-	Doors             DoorGroup   `json:"doors,omitempty"`
-}
-
-type RoomRegistrationResp struct {
-	Id    string              `json:"_id,omitempty"`
-	Rev   string              `json:"_rev,omitempty"`
-	Owner string              `json:"owner,omitempty"`
-	Info  RoomRegistrationReq `json:"info,omitempty"`
-	Type  string              `json:"type,omitempty"`
+// Returns a map service client that signs with our id and secret and
+// retries as -retries, -between and -maxBetween direct.
+func newMapClient(hc *http.Client) *mapclient.Client {
+	mc := mapclient.New(hc, fmt.Sprintf("%s://%s/map/v1", config.protocol, config.gameonAddr),
+		config.id, sharedSecret())
+	// Sign with whatever our secret is now, not when we started.
+	mc.CurrentSecret = sharedSecret
+	mc.Timeout = time.Duration(config.mapTimeout) * time.Second
+	mc.Retry.Attempts = config.retries
+	mc.Retry.Initial = time.Duration(config.secondsBetween) * time.Second
+	mc.Retry.Max = time.Duration(config.maxBetween) * time.Second
+	mc.Date = makeTimestamp
+	if config.autoSkew {
+		mc.DateSeen = clockSkew.observe
+	}
+	mc.Logf = func(format string, args ...interface{}) {
+		checkpoint("MAPCLIENT", fmt.Sprintf(format, args...))
+	}
+	return mc
 }
 
-// Registers our room with the GameOn! server, with retries on failure.
-func registerWithRetries(client *http.Client) (e error) {
+// Registers each of our rooms (see roomSpecs) with the GameOn! server,
+// with retries on failure, and then learns which rooms we serve.
+func registerWithRetries(mc *mapclient.Client) (e error) {
 	locus := "REG_W_RETRIES"
-	checkpoint(locus, fmt.Sprintf("retries=%d secondsBetween=%d", config.retries, config.secondsBetween))
-	for i := 0; i < config.retries; i++ {
-		checkpoint(locus, fmt.Sprintf("Begin attempt %d of %d",
-			i+1, config.retries))
-		e = register(client)
-		if e == nil {
-			checkpoint(locus, "Registration was successful.")
-			rememberMyRooms(client)
-			return
+	checkpoint(locus, fmt.Sprintf("retries=%d secondsBetween=%d maxBetween=%d",
+		config.retries, config.secondsBetween, config.maxBetween))
+	ctx := context.Background()
+	for _, spec := range roomSpecs {
+		var action string
+		action, e = register(ctx, mc, spec)
+		registrationState.record(spec, action, e)
+		if action == RegDegraded {
+			// We serve the site as it is and the watchdog tries
+			// the update again.
+			checkpoint(locus, fmt.Sprintf("Update of %s failed; serving it as it is.", spec.Name))
+			e = nil
+			continue
 		}
-		checkpoint(locus, fmt.Sprintf("sleeping %d seconds.", config.secondsBetween))
-		if i+1 < config.retries {
-			time.Sleep(time.Duration(config.secondsBetween) * time.Second)
+		if e != nil {
+			checkpoint(locus, fmt.Sprintf("Registration of %s failed.", spec.Name))
+			e = RegError{fmt.Sprintf("Registration of %s failed: %s", spec.Name, e.Error())}
+			return
 		}
 	}
-	checkpoint(locus, "Registration failed.")
-	e = RegError{fmt.Sprintf("Timed out. Last error: %s", e.Error())}
+	checkpoint(locus, "Registration was successful.")
+	registrationState.checked(rememberMyRooms(ctx, mc))
 	return
 }
 
-// Registers our room with the game-on server if the room is not
-// already registered.
-func register(client *http.Client) (err error) {
+// What register did about a room.
+const (
+	RegUnchanged  = "unchanged"
+	RegUpdated    = "updated"
+	RegRegistered = "registered"
+	// The room is registered, but an update that it needs failed.
+	RegDegraded = "degraded"
+)
+
+// Registers a room with the game-on server if the room is not
+// already registered, or updates its registration if that differs
+// from our settings. Returns one of the Reg constants above; if it is
+// RegDegraded, err says why the update failed.
+func register(ctx context.Context, mc *mapclient.Client, spec *roomSpec) (action string, err error) {
 	locus := "REG"
-	checkpoint(locus, "Begin")
+	checkpoint(locus, fmt.Sprintf("Begin %s", spec.Name))
+	action = RegUnchanged
 
-	var registered bool
-	registered, err = checkForPriorRegistration(client)
+	var site *mapclient.Site
+	site, err = checkForPriorRegistration(ctx, mc, spec)
 	if err != nil {
 		checkpoint(locus, fmt.Sprintf("err=%s", err.Error()))
 		return
 	}
-	if registered {
-		checkpoint(locus, fmt.Sprintf("WasAlreadyRegistered %s", config.roomName))
+	if site != nil {
+		checkpoint(locus, fmt.Sprintf("WasAlreadyRegistered %s", spec.Name))
+		if config.noUpdate {
+			return
+		}
+		var changes []mapclient.Change
+		changes, err = updateOurRoom(ctx, mc, spec, site.Id)
+		if err != nil {
+			checkpoint(locus, fmt.Sprintf("UpdateFailed err=%s", err.Error()))
+			action = RegDegraded
+		} else if len(changes) > 0 {
+			action = RegUpdated
+		}
 		return
 	}
 	checkpoint(locus, "WeNeedToRegister")
-	err = registerOurRoom(client)
+	err = registerOurRoom(ctx, mc, spec)
 	if err == nil {
-		checkpoint(locus, fmt.Sprintf("Registered %s", config.roomName))
+		checkpoint(locus, fmt.Sprintf("Registered %s", spec.Name))
+		action = RegRegistered
 	} else {
 		checkpoint(locus, fmt.Sprintf("RegistrationFailed err=%s", err.Error()))
 	}
 	return
 }
 
-func checkForPriorRegistration(client *http.Client) (registered bool, err error) {
+// Returns our existing registration of the room, or nil if there is
+// none.
+func checkForPriorRegistration(ctx context.Context, mc *mapclient.Client, spec *roomSpec) (site *mapclient.Site, err error) {
 	locus := "REG.CHECKPRIOR"
 	checkpoint(locus, "Begin")
-	queryParams := fmt.Sprintf("name=%s&owner=%s", config.roomName, config.id)
-	u := fmt.Sprintf("http://%s/map/v1/sites?%s",
-		config.gameonAddr,
-		queryParams)
-	fmt.Println(u)
-	if config.debug {
-		checkpoint(locus, fmt.Sprintf("GET.URL %s", u))
-	}
-
-	req, err := http.NewRequest("GET", u, nil)
-	if err != nil {
-		checkpoint(locus, fmt.Sprintf("NewRequest.Error err=%s", err.Error()))
-		return
-	}
-	resp, err := client.Do(req)
+	sites, err := mc.List(ctx, mapclient.ListOptions{Owner: config.id, Name: spec.Name})
 	if err != nil {
-		checkpoint(locus, fmt.Sprintf("Get.Error err=%s", err.Error()))
+		checkpoint(locus, fmt.Sprintf("List.Error err=%s", err.Error()))
 		return
 	}
-	body, err := extractBody(resp)
-	if err != nil {
-		checkpoint(locus, fmt.Sprintf("Body.Error err=%s", err.Error()))
-		return
-	}
-	switch resp.StatusCode {
-	case http.StatusOK:
-		checkpoint(locus, "AlreadyRegistered")
-		registered = true
-		traceResponseBody(locus, resp, body)
-		if config.debug {
-			// We expect a one element array from our query.
-			// Do not propagate any errors from this debug logging.
-			var regResp [1]RoomRegistrationResp
-			e := json.Unmarshal([]byte(body), &regResp)
-			if e != nil {
-				log.Printf("%s: JSON unmarshalling error: %s\n",
-					locus, e.Error())
-				log.Printf("%s : Offending JSON: %s\n", locus, body)
-				return
-			}
-			rememberedRegistration.Id = regResp[0].Id
-			printRoomRegistrationResp(locus, &regResp[0])
-		}
-		return
-	case http.StatusNoContent:
+	if len(sites) == 0 {
 		checkpoint(locus, "NotCurrentlyRegistered")
-		traceResponseBody(locus, resp, body)
-		return
-	default:
-		checkpoint(locus, "UnsupportedStatusCode")
-		printResponseBody(locus, resp, body)
 		return
 	}
+	checkpoint(locus, "AlreadyRegistered")
+	site = &sites[0]
+	rememberRegistration(spec, site)
+	if config.debug {
+		printSite(locus, site)
+	}
+	return
 }
 
-func registerOurRoom(client *http.Client) (err error) {
+func registerOurRoom(ctx context.Context, mc *mapclient.Client, spec *roomSpec) (err error) {
 	locus := "REG.REGROOM"
 	checkpoint(locus, "Begin")
-	var registration string
-	registration, err = genRegistration()
-	if err != nil {
-		log.Printf("\nREG.REGROOM.InternalError\n")
-		log.Printf("Internal registration error: %s\n", err.Error())
-		return
+	registration := genRegistration(spec)
+	if config.debug {
+		j, _ := json.Marshal(registration)
+		checkpoint(locus, fmt.Sprintf("registration json=%s", j))
 	}
 
-	u := fmt.Sprintf("%s://%s/map/v1/sites", config.protocol, config.gameonAddr)
-
-	if config.debug {
-		log.Printf("\nREG.POST URL: %s\n", u)
-		log.Println("----- registration json begin -----")
-		log.Println(registration)
-		log.Println("----- registration json end -----")
+	site, err := mc.Create(ctx, registration)
+	switch {
+	case err == nil:
+		checkpoint(locus, "Registered")
+		rememberRegistration(spec, site)
+		noteRegisteredToken(spec, site, registration.ConnectionDetails.Token)
+		if config.debug {
+			printSite(locus, site)
+		}
+	case mapclient.IsConflict(err):
+		checkpoint(locus, fmt.Sprintf("Internal Error. Attempt to reregister. err=%s", err.Error()))
+		err = RegError{err.Error()}
+	default:
+		checkpoint(locus, fmt.Sprintf("Failed err=%s", err.Error()))
+		err = RegError{err.Error()}
 	}
+	return
+}
 
-	req, err := http.NewRequest("POST", u, strings.NewReader(registration))
-	if err != nil {
-		checkpoint(locus, fmt.Sprintf("NewRequest.Error err=%s", err.Error()))
+// Brings the registration of the site with the given id into line
+// with genRegistration, logging each field that changes. Nothing is
+// sent if the registration is already up to date. Returns the fields
+// that differed.
+func updateOurRoom(ctx context.Context, mc *mapclient.Client, spec *roomSpec, id string) (changes []mapclient.Change, err error) {
+	locus := "REG.UPDATE"
+	checkpoint(locus, fmt.Sprintf("Begin _id=%s", id))
+	site, want, changes, err := compareRegistration(ctx, mc, spec, id)
+	if err != nil || len(changes) == 0 {
 		return
 	}
+	updated, err := mc.Update(ctx, id, site.Rev, mapclient.Merge(site.Info, want))
+	switch {
+	case err == nil:
+		checkpoint(locus, fmt.Sprintf("Updated %d fields", len(changes)))
+		rememberRegistration(spec, updated)
+		noteRegisteredToken(spec, updated, want.ConnectionDetails.Token)
+		if config.debug {
+			printSite(locus, updated)
+		}
+	case mapclient.IsConflict(err):
+		checkpoint(locus, fmt.Sprintf("Changed by someone else meanwhile. err=%s", err.Error()))
+		err = RegError{err.Error()}
+	default:
+		checkpoint(locus, fmt.Sprintf("Failed err=%s", err.Error()))
+		err = RegError{err.Error()}
+	}
+	return
+}
 
-	addAuthenticationHeaders(req, registration)
-
-	resp, err := client.Do(req)
+// Fetches the site with the given id and returns it, the registration
+// we want for spec, and the fields in which they differ, logging each.
+func compareRegistration(ctx context.Context, mc *mapclient.Client, spec *roomSpec, id string) (
+	site *mapclient.Site, want *mapclient.RoomInfo, changes []mapclient.Change, err error) {
+	locus := "REG.COMPARE"
+	site, err = mc.Get(ctx, id)
 	if err != nil {
-		checkpoint(locus, fmt.Sprintf("Post.Error err=%s", err.Error()))
+		checkpoint(locus, fmt.Sprintf("Get.Error err=%s", err.Error()))
 		return
 	}
-	body, err := extractBody(resp)
-	if err != nil {
-		checkpoint(locus, fmt.Sprintf("Body.Error err=%s", err.Error()))
-		return
+	want = genRegistration(spec)
+	changes = mapclient.Diff(site.Info, want)
+	if tokenConfirmed(spec, site) {
+		changes = withoutField(changes, "connectionDetails.token")
 	}
-	checkpoint(locus, fmt.Sprintf("Status=%s", resp.Status))
-	switch resp.StatusCode {
-	case http.StatusCreated:
-		checkpoint(locus, "Registered")
-		traceResponseBody(locus, resp, body)
-		err = rememberRegistration(resp, body)
-		return
-	case http.StatusConflict:
-		err = RegError{fmt.Sprintf("Bad status: %s", resp.Status)}
-		checkpoint(locus, fmt.Sprintf("Internal Error. Attempt to reregister. Status=%s", resp.Status))
-		printResponseBody(locus, resp, body)
-		return
-	default:
-		err = RegError{fmt.Sprintf("Unhandled Status: %s", resp.Status)}
-		checkpoint(locus, fmt.Sprintf("Unhandled Status=%s", resp.Status))
-		printResponseBody(locus, resp, body)
+	if len(changes) == 0 {
+		checkpoint(locus, "UpToDate")
 		return
 	}
+	for _, c := range changes {
+		checkpoint(locus, fmt.Sprintf("CHANGED %s '%s' -> '%s'", c.Field, c.Old, c.New))
+	}
+	return
 }
 
-func printRoomRegistrationResp(locus string, r *RoomRegistrationResp) {
-	j, err := json.MarshalIndent(r, "", "    ")
-	if err == nil {
-		log.Printf("\n%s\n%s\n", locus, string(j))
+// Returns changes without the change to field, if there is one.
+func withoutField(changes []mapclient.Change, field string) []mapclient.Change {
+	var kept []mapclient.Change
+	for _, c := range changes {
+		if c.Field != field {
+			kept = append(kept, c)
+		}
 	}
+	return kept
+}
+
+// Logs what we need to know about site, which is not its token.
+func printSite(locus string, site *mapclient.Site) {
+	name, target := siteNameAndTarget(site)
+	checkpoint(locus, fmt.Sprintf("SITE _id=%s rev=%s name=%s callback=%s", site.Id, site.Rev, name, target))
 }
 
-// Generate a JSON string containing our registration info.
-func genRegistration() (rs string, err error) {
-	var reg RoomRegistrationReq
-	reg.Name = config.roomName
-	reg.FullName = config.roomName
+// Returns the registration info for a room.
+func genRegistration(spec *roomSpec) *mapclient.RoomInfo {
 	// Door descriptions are collected from an inside-looking-out
 	// perspective, but Game On! wants a description from the
 	// connecting room's point of view. So, our commandline
 	// North is what GameOn! wants for the South.
-	reg.Doors.North = config.south
-	reg.Doors.South = config.north
-	reg.Doors.East = config.west
-	reg.Doors.West = config.east
-	reg.Doors.Up = config.down
-	reg.Doors.Down = config.up
-	reg.ConnectionDetails.Type = "websocket"
-	reg.ConnectionDetails.Target = fmt.Sprintf("ws://%s:%d",
-		config.callbackAddr, config.callbackPort)
-	j, err := json.MarshalIndent(reg, "", "    ")
-	if err != nil {
-		return
+	return &mapclient.RoomInfo{
+		Name:        spec.Name,
+		FullName:    spec.FullName,
+		Description: spec.Description,
+		Doors: &mapclient.Doors{
+			North: spec.Doors.South,
+			South: spec.Doors.North,
+			East:  spec.Doors.West,
+			West:  spec.Doors.East,
+			Up:    spec.Doors.Down,
+			Down:  spec.Doors.Up,
+		},
+		ConnectionDetails: &mapclient.ConnectionDetails{
+			Type:   "websocket",
+			Target: callbackTarget(),
+			Token:  config.token,
+		},
 	}
-	rs = string(j)
-	return
 }
 
-// Conditionally print an http.Response body string
-// if config.debug is true.
-func traceResponseBody(locus string, r *http.Response, body string) {
-	if config.debug {
-		printResponseBody(locus, r, body)
+// Returns the websocket address that Game On! should use to reach us.
+// The port is left out if it is the default for the scheme, as it
+// usually is behind an ingress.
+func callbackTarget() string {
+	host := config.callbackAddr
+	if !(config.callbackScheme == "ws" && config.callbackPort == 80) &&
+		!(config.callbackScheme == "wss" && config.callbackPort == 443) {
+		host = fmt.Sprintf("%s:%d", host, config.callbackPort)
 	}
+	return fmt.Sprintf("%s://%s%s", config.callbackScheme, host, config.callbackPath)
 }
 
-// Unconditionally print an http.Response body string.
-func printResponseBody(locus string, r *http.Response, body string) {
-	log.Printf("\n%s.RESP.StatusCode=%s\n", locus, r.Status)
-	if r.StatusCode == http.StatusNotFound {
-		// Avoid the noise.
-		return
-	}
-	log.Printf("%s.RESP.Body='%s'\n", locus, body)
+// Returns whether target, a callback registered with Game On!, is
+// ours. The scheme and host are not case sensitive, a default port
+// may be left out, and no path at all is taken for /ws, which we
+// always serve and which earlier versions of this room registered
+// without a path.
+func isOurTarget(target string) bool {
+	return normalizeTarget(target) == normalizeTarget(callbackTarget())
 }
 
-// We stash our room registration response in rememberedRegistration
-// so that we can use it later if we choose to do so.
-var rememberedRegistration RoomRegistrationResp
-
-// Remember our registration data in case we chose to use it later.
-func rememberRegistration(r *http.Response, body string) (err error) {
-	locus := "REG.REMEMBER"
-	var rememberedRegistration RoomRegistrationResp
-	err = json.Unmarshal([]byte(body), &rememberedRegistration)
-	if err != nil {
-		log.Printf("rememberRegistration : A JSON unmarshalling error occured: %s\n",
-			err.Error())
-		log.Printf("rememberRegistration :Offending JSON: %s\n", body)
-		return
+// Returns target in the form that isOurTarget compares.
+func normalizeTarget(target string) string {
+	u, err := url.Parse(strings.TrimSpace(target))
+	if err != nil || len(u.Host) == 0 {
+		return target
 	}
-	if config.debug {
-		printRoomRegistrationResp(locus, &rememberedRegistration)
+	scheme := strings.ToLower(u.Scheme)
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (scheme == "ws" && port == "80") || (scheme == "wss" && port == "443") {
+		port = ""
 	}
-	return
+	if len(port) > 0 {
+		host = net.JoinHostPort(host, port)
+	}
+	path := u.Path
+	if len(path) == 0 {
+		path = "/ws"
+	}
+	return scheme + "://" + host + path
 }
 
-// Returns the body string from an http.Response. This can
-// only be called once as the body is closed after reading
-// its contents.
-func extractBody(r *http.Response) (body string, e error) {
-	defer r.Body.Close()
-	b, e := ioutil.ReadAll(r.Body)
-	if e == nil {
-		body = string(b)
-	}
-	return
+// We stash our room registrations, by name, in rememberedRegistrations
+// so that we can use them later if we choose to do so. Only use it
+// while holding rememberedRegistrationsMu, or through
+// rememberRegistration and rememberedRegistration.
+var (
+	rememberedRegistrations   = make(map[string]*mapclient.Site)
+	rememberedRegistrationsMu sync.Mutex
+)
+
+// Remembers site as the registration of spec.
+func rememberRegistration(spec *roomSpec, site *mapclient.Site) {
+	rememberedRegistrationsMu.Lock()
+	defer rememberedRegistrationsMu.Unlock()
+	rememberedRegistrations[spec.Name] = site
 }
 
-type RoomQueryResp struct {
-	Id    string              `json:"_id,omitempty"`
-	Owner string              `json:"owner,omitempty"`
-	Info  RoomRegistrationReq `json:"info,omitempty"`
+// Returns the registration of the room with the given name that we
+// last saw, or nil.
+func rememberedRegistration(name string) *mapclient.Site {
+	rememberedRegistrationsMu.Lock()
+	defer rememberedRegistrationsMu.Unlock()
+	return rememberedRegistrations[name]
 }
 
-var MyRooms map[string]string
+// MyRooms maps the id of every site registered by our Game On! id to
+// its full name. Those sites whose callback is ours are also added to
+// roomRouter so that we serve them. It is replaced, never modified,
+// and only while holding myRoomsMu.
+var (
+	MyRooms   map[string]string
+	myRoomsMu sync.RWMutex
+)
 
-func rememberMyRooms(client *http.Client) (err error) {
+// Lists the sites registered by our Game On! id, refreshes MyRooms,
+// and makes roomRouter serve exactly those sites whose callback is
+// ours, together with our rooms (see roomSpecs) whose callback has
+// drifted, which is logged. Nothing changes if the map service cannot
+// be reached.
+func rememberMyRooms(ctx context.Context, mc *mapclient.Client) (err error) {
 	locus := "REG.LISTMYROOMS"
-	MyRooms = make(map[string]string)
-	u := fmt.Sprintf("%s://%s/map/v1/sites?owner=%s",
-		config.protocol, config.gameonAddr, config.id)
-	checkpoint(locus, u)
-	req, err := http.NewRequest("GET", u, nil)
-	if err != nil {
-		checkpoint(locus, fmt.Sprintf("NewRequest.Error err=%s", err.Error()))
-		return
-	}
-
-	addAuthenticationHeaders(req, "")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		checkpoint(locus, fmt.Sprintf("Get.Error err=%s", err.Error()))
-		return
-	}
-	body, err := extractBody(resp)
+	sites, err := listMySites(ctx, mc)
 	if err != nil {
-		checkpoint(locus, fmt.Sprintf("Body.Error err=%s", err.Error()))
 		return
 	}
-	traceResponseBody(locus, resp, body)
-	switch resp.StatusCode {
-	case http.StatusOK:
-		var qr [MaxRegQueries]RoomQueryResp
-		e := json.Unmarshal([]byte(body), &qr)
-		if e != nil {
-			log.Printf("%s: JSON unmarshalling error: %s\n",
-				locus, e.Error())
-			log.Printf("%s : Offending JSON: %s\n", locus, body)
-			return
+	mine := make(map[string]string)
+	served := make(map[string]bool)
+	for _, site := range sites {
+		if len(site.Id) == 0 || site.Info == nil {
+			continue
 		}
-		for _, r := range qr {
-			if len(r.Id) > 0 {
-				MyRooms[r.Id] = r.Info.FullName
-			}
+		mine[site.Id] = site.Info.FullName
+		var theirs string
+		if site.Info.ConnectionDetails != nil {
+			theirs = site.Info.ConnectionDetails.Target
 		}
-		if config.debug {
-			for k, v := range MyRooms {
-				log.Printf("%s --> %s\n", k, v)
+		if !isOurTarget(theirs) {
+			if findRoomSpec(site.Info.Name) == nil {
+				if config.debug {
+					checkpoint(locus, fmt.Sprintf("NOT.OURS %s callback=%s", site.Id, theirs))
+				}
+				continue
 			}
+			// The watchdog puts it right unless -noupdate is given.
+			checkpoint(locus, fmt.Sprintf("DRIFTED %s as %s callback=%s", site.Id, site.Info.Name, theirs))
 		}
+		served[site.Id] = true
+		if r := roomRouter.Lookup(site.Id); r != nil && r.name == site.Info.Name && r.fullName == site.Info.FullName {
+			continue
+		}
+		checkpoint(locus, fmt.Sprintf("SERVING %s as %s", site.Id, site.Info.Name))
+		if spec := findRoomSpec(site.Info.Name); spec != nil {
+			roomRouter.Add(newRoomFromSpec(site.Id, spec))
+		} else {
+			roomRouter.Add(newRoom(site.Id, site.Info.Name, site.Info.FullName))
+		}
+	}
+	for _, r := range roomRouter.Rooms() {
+		if !served[r.id] {
+			checkpoint(locus, fmt.Sprintf("NO.LONGER.SERVING %s", r.id))
+			roomRouter.Remove(r.id)
+		}
+	}
+	myRoomsMu.Lock()
+	MyRooms = mine
+	myRoomsMu.Unlock()
+	if config.debug {
+		for k, v := range mine {
+			checkpoint(locus, fmt.Sprintf("MINE _id=%s fullName=%q", k, v))
+		}
+	}
+	return
+}
+
+// Returns the sites registered by our Game On! id, at most
+// MaxRegQueries of them.
+func listMySites(ctx context.Context, mc *mapclient.Client) (sites []mapclient.Site, err error) {
+	locus := "REG.LISTMYSITES"
+	sites, err = mc.List(ctx, mapclient.ListOptions{Owner: config.id})
+	if err != nil {
+		checkpoint(locus, fmt.Sprintf("FAILED. err=%s", err.Error()))
 		return
-	default:
-		checkpoint(locus, "FAILED.")
+	}
+	if len(sites) > MaxRegQueries {
+		checkpoint(locus, fmt.Sprintf("TRUNCATED %d sites to %d", len(sites), MaxRegQueries))
+		sites = sites[:MaxRegQueries]
 	}
 	return
 }
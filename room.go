@@ -7,15 +7,8 @@ package main
 
 import (
 	"fmt"
-	"github.com/gorilla/websocket"
+	"sample-room-golang/gameon/protocol"
 	"strings"
-	log "github.com/sirupsen/logrus"
-)
-
-const (
-	// GameOn Message Types
-	MTPlayer         = "player"
-	MTPlayerLocation = "playerLocation"
 )
 
 // Handles commands specific to, and implemented by, our room.
@@ -23,19 +16,23 @@ const (
 // must contain a non-empty Content field. If the Content field
 // begins with a slash ("/") then the request is treated as a
 // room command, otherwise it is treated as a chat request.
-func handleRoom(conn *websocket.Conn, req *GameonRequest, room string) error {
+func handleRoom(sess *Session, req *protocol.Command, room *Room) error {
 	content := req.Content
 	if len(content) < 1 {
 		return JSPayloadError{"There is no content."}
 	}
 	if 0 == strings.Index(content, "/") {
-		return handleSlashCommand(conn, req, room)
+		return handleSlashCommand(sess, req, room)
+	}
+	if !admitRequest(sess, req, room, LimitChat) {
+		return nil
 	}
-	return handleChat(conn, req, room)
+	return handleChat(sess, req, room)
 }
 
 const (
 	// Slash commands, without the actual '/', of course.
+	slashDoors     = "DOORS"
 	slashExamine   = "EXAMINE"
 	slashGo        = "GO"
 	slashInventory = "INVENTORY"
@@ -43,9 +40,6 @@ const (
 	slashWink      = "WINK"
 )
 
-// The is the list of commands that we are willing to catch.
-var commandsWeSupport = []string{slashExamine, slashGo, slashInventory, slashLook, slashWink}
-
 type CommandDesc struct {
 	cmd  string
 	desc string
@@ -55,40 +49,40 @@ type CommandDesc struct {
 // When a player enters our room, we will need to tell them game about these
 // commands so that the game knows to add them to /help output.
 var commandsWeAdd = []CommandDesc{
+	{"/doors", "List the doors out of this room and where they lead."},
 	{"/wink", "(You wonder what this would do.)"},
 }
 
 // Recognizes and dispatches a room slash command. Nil is returned
 // if all goes well, otherwise an error is returned.
-func handleSlashCommand(conn *websocket.Conn, req *GameonRequest, room string) error {
+func handleSlashCommand(sess *Session, req *protocol.Command, room *Room) error {
 	locus := "HANDLE.SLASH"
-	cmd, tail, err := parseCommandPrefix(req.Content)
+	cmd, tail, err := parseCommandPrefix(req.Content, room)
 	if err != nil {
-		SendMessageToPlayer(conn, "What? I didn't understand that.", req.UserId)
+		// A command we cannot parse still costs the player a token,
+		// or a flood of them would never be limited.
+		if !admitRequest(sess, req, room, LimitCommand) {
+			return nil
+		}
+		SendMessageToPlayer(sess, "What? I didn't understand that.", req.UserId)
 		return err
 	}
-	checkpoint(locus, fmt.Sprintf("cmd=%s tail=%s", cmd, tail))
-	switch cmd {
-	case slashGo:
-		return exitRoom(conn, req, tail, room)
-	case slashLook:
-		return lookAroundRoom(conn, req, tail, room)
-	case slashInventory:
-		return checkInventory(conn, req, tail, room)
-	case slashExamine:
-		return examineObject(conn, req, tail, room)
-	case slashWink:
-		return wink(conn, req, tail, room)
-	default:
-		SendMessageToPlayer(conn, "What? I didn't understand that.", req.UserId)
+	if !admitRequest(sess, req, room, commandLimitClass(cmd)) {
+		return nil
+	}
+	checkpoint(locus, fmt.Sprintf("cmd=%s tail=%q", cmd, tail))
+	handler := room.commands[cmd]
+	if handler == nil {
+		SendMessageToPlayer(sess, "What? I didn't understand that.", req.UserId)
 		return JSPayloadError{fmt.Sprintf("Unrecognized command: '%s'", cmd)}
 	}
+	return handler(sess, req, tail, room)
 }
 
 // Parse the Content field of a request and return the
 // command string and the remainder if the command is
-// recognized, otherwise return a non-nil error.
-func parseCommandPrefix(s string) (cmd, tail string, err error) {
+// one that room recognizes, otherwise return a non-nil error.
+func parseCommandPrefix(s string, room *Room) (cmd, tail string, err error) {
 	const minCommandLen = 2
 	if len(s) < minCommandLen {
 		err = JSPayloadError{"Invalid command format: Less than minimum length."}
@@ -101,10 +95,7 @@ func parseCommandPrefix(s string) (cmd, tail string, err error) {
 	haystackAsis := s[1:]
 	haystack := strings.ToUpper(s[1:])
 	hlen := len(haystack)
-	for _, key := range commandsWeSupport {
-		if config.debug {
-			log.Printf("parseCommandPrefix: '%s' vs '%s'\n", key, haystack)
-		}
+	for _, key := range room.commandWords() {
 		n := len(key)
 		if hlen < n {
 			continue
@@ -124,6 +115,7 @@ func parseCommandPrefix(s string) (cmd, tail string, err error) {
 			return
 		}
 	}
-	err = JSPayloadError{fmt.Sprintf("Unrecognized command in '%s'", s)}
+	// What the player said is theirs; only its length is logged.
+	err = JSPayloadError{fmt.Sprintf("Unrecognized command in %d bytes of content", len(s))}
 	return
 }
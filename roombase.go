@@ -5,55 +5,41 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"net/http"
-	"strings"
+	"sample-room-golang/gameon/protocol"
 )
 
-const (
-	roomHello   = "roomHello"
-	roomGoodbye = "roomGoodbye"
-	lookAround  = "look"
-)
-
-// roomHello,43a4d07399ea23d648568c6d2d000b65,
-// {"version": 1,"username": "DevUser","userId": "dummy.DevUser"}
-type HelloMessage struct {
-	Version  int    `json:"version,omitempty"`
-	UserId   string `json:"userId,omitempty"`
-	Username string `json:"username,omitempty"`
-}
-
-// roomGoodbye,43a4d07399ea23d648568c6d2d000b65,
-// {"username": "DevUser","userId": "dummy.DevUser"}
-type GoodbyeMessage struct {
-	UserId   string `json:"userId,omitempty"`
-	Username string `json:"username,omitempty"`
-}
-
-// room,43a4d07399ea23d648568c6d2d000b65,
-// {"username":"DevUser","userId":"dummy.DevUser","content":"/examine book"}
-type GameonRequest struct {
-	UserId   string `json:"userId,omitempty"`
-	Username string `json:"username,omitempty"`
-	Content  string `json:"content,omitempty"`
-}
+// The messages we receive are decoded by the protocol package
+// (gameon/protocol). For example:
+//
+//   roomHello,43a4d07399ea23d648568c6d2d000b65,
+//   {"version": 1,"username": "DevUser","userId": "dummy.DevUser"}
+//     is a *protocol.Hello
+//   roomGoodbye,43a4d07399ea23d648568c6d2d000b65,
+//   {"username": "DevUser","userId": "dummy.DevUser"}
+//     is a *protocol.Goodbye
+//   room,43a4d07399ea23d648568c6d2d000b65,
+//   {"username":"DevUser","userId":"dummy.DevUser","content":"/examine book"}
+//     is a *protocol.Command
+//
+// and, in protocol version 2 only, roomJoin and roomPart which are
+// a *protocol.Join and a *protocol.Part.
 
 var (
 	ExpectedMessageType = websocket.TextMessage
 	upgrader            = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+		CheckOrigin:     checkOrigin,
 	}
 	// Supported versions is the list of version numbers
-	// that we are willing to support. Currently we only
-	// support version 1.
-	SupportedVersions = []int{1}
+	// that we are willing to support. Version 2 adds the
+	// roomJoin and roomPart messages. The mediator picks one
+	// from the list we send in our ack and tells us which in
+	// the first roomHello or roomJoin on the connection.
+	SupportedVersions = []int{1, 2}
 )
 
 // Handles incoming requests directed to our room.
@@ -66,78 +52,95 @@ func roomHandler(w http.ResponseWriter, r *http.Request) {
 	locus := "ROOM.HANDLER"
 	checkpoint(locus, "BEGIN")
 
-	// For debugging purposes
-	fmt.Println("HERE: ", r.Header.Get("gameon-signature"))
-	fmt.Println("HERE: ", r.Header.Get("gameon-date"))
+	if !wsAccess.allows(r.RemoteAddr) {
+		denied(wsAccess.name, r.RemoteAddr, r.URL.Path, "not on the ws allowlist")
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	err := verifyHandshake(r)
+	if err != nil {
+		checkpoint(locus, fmt.Sprintf("HANDSHAKE.REJECTED err=%s", err.Error()))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	headers := getHandshakeHeader(r)
-	fmt.Println(headers)
-
-	w.Header().Set("gameon-signature", headers["gameon-signature"][0])
-	w.Header().Set("gameon-date", headers["gameon-date"][0])
 
 	conn, err := upgrader.Upgrade(w, r, headers)
 	if err != nil {
 		checkpoint(locus, fmt.Sprintf("WS.ERROR err=%s", err.Error()))
 		checkpoint(locus, "BYE-BYE Room")
-		conn.Close()
 		return
 	}
-	ack(conn)
+	recordHandshake(r)
+	conn.SetReadLimit(int64(config.maxMessageSize))
+	sess := NewSession(conn)
+	defer func() {
+		sess.Close()
+		sess.departAll()
+	}()
+	ack(sess)
 
 	for {
 		checkpoint(locus, "READ We are waiting for a message.")
-		_, payload, err := conn.ReadMessage()
+		mt, payload, err := conn.ReadMessage()
 		if err != nil {
 			checkpoint(locus, fmt.Sprintf("UNREADABLE.MESSAGE err=%s", err.Error()))
 			checkpoint(locus, "BYE-BYE Room")
-			conn.Close()
 			return
 		}
-		cmd, room, j, err := parseRequest(payload)
+		sess.receivedMessage()
+		if mt != ExpectedMessageType {
+			err = PayloadError{fmt.Sprintf("Unexpected websocket message type %d", mt)}
+			if rejectMalformed(sess, nil, err) {
+				return
+			}
+			continue
+		}
+		frame, err := parseRequest(payload)
 		if err != nil {
 			checkpoint(locus, fmt.Sprintf("PARSE.ERROR err=%s", err.Error()))
+			if rejectMalformed(sess, nil, err) {
+				return
+			}
 			continue
 		}
 
-		if config.debug {
-			checkpoint(locus, fmt.Sprintf("PARSE cmd=%s", cmd))
-			checkpoint(locus, fmt.Sprintf("PARSE room=%s", room))
-			checkpoint(locus, fmt.Sprintf("PARSE json=%s", j))
+		room := roomRouter.Lookup(frame.Target)
+		if room == nil {
+			err = rejectUnknownRoom(sess, frame)
+			checkpoint(locus, fmt.Sprintf("ROUTING.ERROR err=%s", err.Error()))
+			continue
 		}
 
-		switch cmd {
-		case "roomHello":
-			var req HelloMessage
-			err = json.Unmarshal([]byte(j), &req)
-			if err != nil {
-				checkpoint(locus, fmt.Sprintf("JSON.UNMARSHALL.ERROR err=%s", err.Error()))
-				checkpoint(locus, fmt.Sprintf("JSON.UNMARSHALL.ERROR Offending JSON=%s", j))
-				continue
-			}
-			err = handleHello(conn, &req, room)
-
-		case "roomGoodbye":
-			var req GoodbyeMessage
-			err = json.Unmarshal([]byte(j), &req)
-			if err != nil {
-				checkpoint(locus, fmt.Sprintf("JSON.UNMARSHALL.ERROR err=%s", err.Error()))
-				checkpoint(locus, fmt.Sprintf("JSON.UNMARSHALL.ERROR Offending JSON=%s", j))
-				continue
-			}
-			err = handleGoodbye(conn, &req, room)
-
-		case "room":
-			var req GameonRequest
-			err = json.Unmarshal([]byte(j), &req)
-			if err != nil {
-				checkpoint(locus, fmt.Sprintf("JSON.UNMARSHALL.ERROR err=%s", err.Error()))
-				checkpoint(locus, fmt.Sprintf("JSON.UNMARSHALL.ERROR Offending JSON=%s", j))
-				continue
+		msg, err := frame.Decode()
+		if err != nil {
+			// The payload is not JSON, so the redactor cannot find
+			// the fields in it that need masking; log only its size.
+			checkpoint(locus, fmt.Sprintf("DECODE.ERROR kind=%s bytes=%d err=%s",
+				frame.Kind, len(frame.Payload), err.Error()))
+			if rejectMalformed(sess, frame, err) {
+				return
 			}
-			err = handleRoom(conn, &req, room)
+			continue
+		}
+
+		switch req := msg.(type) {
+		case *protocol.Hello:
+			err = handleHello(sess, req, room)
+		case *protocol.Goodbye:
+			err = handleGoodbye(sess, req, room)
+		case *protocol.Join:
+			err = handleJoin(sess, req, room)
+		case *protocol.Part:
+			err = handlePart(sess, req, room)
+		case *protocol.Command:
+			err = handleRoom(sess, req, room)
 		default:
-			err = handleInvalidMessage(conn, payload)
+			err = handleInvalidMessage(sess, frame)
+			if rejectMalformed(sess, frame, err) {
+				return
+			}
 		}
 		if err != nil {
 			checkpoint(locus, fmt.Sprintf("HANDLING.ERROR err=%s", err.Error()))
@@ -145,59 +148,57 @@ func roomHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Returns the three components of a room request payload:
-// command, room, and JSON payload. An error is also returned
-// and the caller must discard any results if the error is not
-// nil.
-// The following checks are made. Any additional checking, such
-// as JSON payload validation, are left to the caller.
-// - There must at least three comma-delimted parts. Additional
-//   commas after the first two are not inspected.
-//   "id,name" is not okay
-//   "id,name,{\"foo\"}"  is okay
-//   "id,name,{\"foo\":\"one,two,three\"}" is okay
-//   "id,name,{this is bad JSON" is, sadly, okay
-// - The 2nd field, which is the target roomname, MUST match the
-//   name we registered our room under.
-func parseRequest(payload []byte) (c, r, j string, err error) {
+// Splits a room request into its three components: command (kind),
+// room id (target) and JSON payload. Any additional checking, such
+// as JSON payload validation and making sure that the room id names
+// a room that we serve, is left to the caller.
+func parseRequest(payload []byte) (f *protocol.Frame, err error) {
 	locus := "PARSE.REQ"
-	s := string(payload)
-	tokens := strings.SplitN(s, ",", 3)
-	if config.debug {
-		checkpoint(locus, s)
-	}
-	if len(tokens) != 3 {
-		err = PayloadError{"Invalid request format."}
+	f, err = protocol.ParseFrame(payload)
+	if err != nil {
+		err = PayloadError{err.Error()}
 		return
 	}
-	c = tokens[0]
-	r = tokens[1]
-	j = tokens[2]
-	checkpoint(locus, fmt.Sprintf("cmd=%s room=%s json=%s", c, r, j))
+	// The payload may not be JSON yet, so we do not log it.
+	checkpoint(locus, fmt.Sprintf("cmd=%s room=%s bytes=%d", f.Kind, f.Target, len(f.Payload)))
 	return
 }
 
-func handleInvalidMessage(conn *websocket.Conn, p []byte) error {
-	return PayloadError{fmt.Sprintf("Unrecognized command in payload '%s'", string(p))}
+func handleInvalidMessage(sess *Session, f *protocol.Frame) error {
+	return PayloadError{fmt.Sprintf("Unexpected %s message of %d bytes", f.Kind, len(f.Payload))}
 }
 
-type WebSocketAck struct {
-	// This is the list of versions that we are willing to support.
-	Version []int `json:"version,omitempty"`
+// Deals with a message that we could not make sense of. If the user
+// id of the player who sent it can be recovered from frame they are
+// told what was wrong. Returns true if this was the connection's
+// -maxMalformed'th bad message, in which case it has been closed.
+func rejectMalformed(sess *Session, frame *protocol.Frame, err error) bool {
+	locus := "MALFORMED"
+	if frame != nil {
+		if uid := protocol.PeekUserId(frame.Payload); len(uid) > 0 {
+			SendMessageToPlayer(sess,
+				fmt.Sprintf("Sorry, the room could not understand that: %s", err.Error()), uid)
+		}
+	}
+	sess.malformed += 1
+	if config.maxMalformed <= 0 || sess.malformed < config.maxMalformed {
+		return false
+	}
+	checkpoint(locus, fmt.Sprintf("CLOSING after %d malformed messages", sess.malformed))
+	sess.Reject(websocket.ClosePolicyViolation, "Too many malformed messages.")
+	return true
 }
 
-// Acknowledges the newly open websocket.
-func ack(conn *websocket.Conn) (e error) {
+// Acknowledges the newly open websocket by telling the mediator
+// which protocol versions we support.
+func ack(sess *Session) (e error) {
 	locus := "ACK"
-	var ack WebSocketAck
-	ack.Version = SupportedVersions
-	j, e := json.MarshalIndent(ack, "", "    ")
+	m, e := protocol.Encode("", &protocol.Ack{Version: SupportedVersions})
 	if e != nil {
 		checkpoint(locus, fmt.Sprintf("FAILED. err=%s", e.Error()))
 		return
 	}
-	var m = fmt.Sprintf("%s,%s", "ack", string(j))
-	e = conn.WriteMessage(ExpectedMessageType, []byte(m))
+	e = sess.Send(m)
 	if config.debug {
 		checkpoint(locus, fmt.Sprintf("MSG=%s", m))
 	}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/http/httptest"
+	"sample-room-golang/gameon/gameontest"
+	"strings"
+	"testing"
+)
+
+// Reads from m until the room closes the connection, and returns the
+// close code, or -1 if the connection ended some other way.
+func closeCode(m *gameontest.Mediator) int {
+	for {
+		_, _, err := m.Next()
+		if ce, ok := err.(*websocket.CloseError); ok {
+			return ce.Code
+		}
+		// Frames that the protocol package cannot decode are skipped.
+		if err != nil && !strings.HasPrefix(err.Error(), "protocol: ") {
+			return -1
+		}
+	}
+}
+
+// Starts roomHandler and dials it as the mediator would.
+func dialRoom(t *testing.T) (*gameontest.Mediator, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(roomHandler))
+	m, err := gameontest.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", config.secret)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("Dial failed: %v", err)
+	}
+	return m, func() {
+		m.Close()
+		srv.Close()
+	}
+}
+
+func TestMalformedMessagesClose(t *testing.T) {
+	ms := gameontest.NewMapService()
+	defer ms.Close()
+	useTestConfig(ms)
+	config.maxMalformed = 3
+	m, done := dialRoom(t)
+	defer done()
+
+	for i := 0; i < config.maxMalformed; i++ {
+		if i == config.maxMalformed-1 {
+			// One short of the limit the connection is still open.
+			const uid = "dummy.Sloppy"
+			if err := m.Say("nowhere", uid, "Sloppy", "/look"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.ExpectEvent(uid, "not served here"); err != nil {
+				t.Fatalf("After %d malformed messages: %v", i, err)
+			}
+		}
+		if err := m.SendRaw([]byte("not a frame")); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+	if code := closeCode(m); code != websocket.ClosePolicyViolation {
+		t.Errorf("After %d malformed messages the room closed with %d", config.maxMalformed, code)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	ms := gameontest.NewMapService()
+	defer ms.Close()
+	useTestConfig(ms)
+	config.maxMessageSize = 512
+	m, done := dialRoom(t)
+	defer done()
+
+	big := "room,nowhere,{\"content\":\"" + strings.Repeat("x", 1024) + "\"}"
+	if err := m.SendRaw([]byte(big)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if code := closeCode(m); code != websocket.CloseMessageTooBig {
+		t.Errorf("A message over -maxMessageSize closed the connection with %d", code)
+	}
+}
+
+func TestRoutingByRoomId(t *testing.T) {
+	ms := gameontest.NewMapService()
+	defer ms.Close()
+	useTestConfig(ms)
+	defer forgetRooms()
+	for i, response := range []string{"A blank page.", "A page of poetry."} {
+		id := []string{"route.1", "route.2"}[i]
+		roomRouter.Add(newRoomFromSpec(id, &roomSpec{Name: id, FullName: id,
+			Commands: []commandSpec{{Name: "read", Response: response}}}))
+	}
+	m, done := dialRoom(t)
+	defer done()
+
+	const uid, name = "dummy.Router", "Router"
+	for id, want := range map[string]string{"route.1": "A blank page.", "route.2": "A page of poetry."} {
+		if err := m.Say(id, uid, name, "/read"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.ExpectEvent(uid, want); err != nil {
+			t.Errorf("/read in %s: %v", id, err)
+		}
+	}
+	if err := m.Say("route.3", uid, name, "/read"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ExpectEvent(uid, "not served here"); err != nil {
+		t.Errorf("A request for a room we do not serve: %v", err)
+	}
+}
+
+func TestRejectedContentIsNotLogged(t *testing.T) {
+	ms := gameontest.NewMapService()
+	defer ms.Close()
+	useTestConfig(ms)
+	defer forgetRooms()
+	roomRouter.Add(newRoom("quiet.room", "QUIET.ROOM", "The Quiet Room"))
+	logger := log.StandardLogger()
+	var buf bytes.Buffer
+	out, formatter := logger.Out, logger.Formatter
+	logger.SetOutput(&buf)
+	logger.SetFormatter(newRedactor(&log.JSONFormatter{}))
+	defer func() {
+		logger.SetOutput(out)
+		logger.SetFormatter(formatter)
+	}()
+	m, done := dialRoom(t)
+	defer done()
+
+	const uid, secret = "dummy.Whisperer", "the cake is a lie"
+	frames := []string{
+		// JSON that the redactor cannot find content in.
+		`room,quiet.room,{"userId":"` + uid + `","content":"` + secret,
+		// A kind that the room does not expect.
+		`player,quiet.room,{"type":"event","content":{"*":"` + secret + `"}}`,
+	}
+	for _, f := range frames {
+		if err := m.SendRaw([]byte(f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Say("quiet.room", uid, "Whisperer", "/"+secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ExpectEvent(uid, "didn't understand"); err != nil {
+		t.Fatal(err)
+	}
+	done()
+	if strings.Contains(buf.String(), "cake") {
+		t.Errorf("Rejected content reached the log:\n%s", buf.String())
+	}
+}
@@ -4,12 +4,11 @@
 
 package main
 
+import "sample-room-golang/gameon/protocol"
+
 // Room /chat command
-import (
-	"github.com/gorilla/websocket"
-)
 
-func handleChat(conn *websocket.Conn, req *GameonRequest, room string) error {
-	BroadcastMessage(room, req.Content, req.Username, "*")
+func handleChat(sess *Session, req *protocol.Command, room *Room) error {
+	BroadcastMessage(room.id, req.Content, req.Username, "*")
 	return nil
 }
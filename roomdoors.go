@@ -0,0 +1,21 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// The /doors room command
+package main
+
+import (
+	"sample-room-golang/gameon/protocol"
+	"strings"
+)
+
+// Lists the doors out of the room and the rooms they lead to. See
+// exits.go.
+func listDoors(sess *Session, req *protocol.Command, tail string, room *Room) error {
+	text := "You cannot make out where the doors lead. Try again later."
+	if lines := neighbours.describe(room.id); len(lines) > 0 {
+		text = strings.Join(lines, "\n")
+	}
+	return SendMessage(sess, req.UserId, protocol.NewEvent(req.UserId, text))
+}
@@ -6,23 +6,13 @@ package main
 
 // Room /examine command
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/gorilla/websocket"
+	"sample-room-golang/gameon/protocol"
 	"strings"
 )
 
-type ExaminationResponse struct {
-	Rtype    string            `json:"type,omitempty"`
-	ExitId   string            `json:"exitId,omitempty"`
-	Content  map[string]string `json:"content,omitempty"`
-	Bookmark int               `json:"bookmark,omitempty"`
-}
-
-func examineObject(conn *websocket.Conn, req *GameonRequest, tail, room string) error {
-	var resp ExaminationResponse
-	resp.Rtype = "event"
-	resp.Content = make(map[string]string)
+func examineObject(sess *Session, req *protocol.Command, tail string, room *Room) error {
+	var text string
 	obj := strings.Trim(tail, " ")
 	if len(obj) > 0 {
 		var verb string
@@ -31,16 +21,11 @@ func examineObject(conn *websocket.Conn, req *GameonRequest, tail, room string)
 		} else {
 			verb = "is"
 		}
-		resp.Content[req.UserId] = fmt.Sprintf("There %s no %s here in %s. Keep moving.",
-			verb, obj, MyRooms[room])
+		text = fmt.Sprintf("There %s no %s here in %s. Keep moving.",
+			verb, obj, room.fullName)
 	} else {
-		resp.Content[req.UserId] = fmt.Sprintf("There is nothing here in %s. Keep moving.",
-			MyRooms[room])
-	}
-
-	j, err := json.MarshalIndent(resp, "", "    ")
-	if err != nil {
-		return err
+		text = fmt.Sprintf("There is nothing here in %s. Keep moving.",
+			room.fullName)
 	}
-	return SendMessage(conn, req.UserId, j, MTPlayer)
+	return SendMessage(sess, req.UserId, protocol.NewEvent(req.UserId, text))
 }
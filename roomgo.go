@@ -6,30 +6,20 @@ package main
 
 // Room /go command
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/gorilla/websocket"
+	"sample-room-golang/gameon/protocol"
 	"strings"
 )
 
-type LocationResponse struct {
-	Rtype    string `json:"type,omitempty"`
-	ExitId   string `json:"exitId,omitempty"`
-	Content  string `json:"content,omitempty"`
-	Bookmark int    `json:"bookmark,omitempty"`
-}
-
 // Exits our room if the player requests a supported exit.
-func exitRoom(conn *websocket.Conn, req *GameonRequest, tail, room string) (e error) {
+func exitRoom(sess *Session, req *protocol.Command, tail string, room *Room) (e error) {
 	locus := "EXITROOM"
 	// Content must be of the form "/go direction" or "/exit direction"
 	// where direction is a valid exit.
 	dir := strings.ToLower(tail)
 	dir = strings.Trim(dir, " ")
 	checkpoint(locus, dir)
-	var lresp LocationResponse
-	lresp.Rtype = "exit"
-	lresp.ExitId = dir
+	lresp := protocol.NewExit(dir, "")
 	validExit := true
 	banter := ""
 	switch dir {
@@ -57,14 +47,15 @@ func exitRoom(conn *websocket.Conn, req *GameonRequest, tail, room string) (e er
 		banter = fmt.Sprintf("'%s'?!? There is no exit with that name. Try again.", dir)
 	}
 
-	SendMessageToPlayer(conn, banter, req.UserId)
-
 	if validExit {
-		j, err := json.MarshalIndent(lresp, "", "    ")
-		if err != nil {
-			return err
+		if x := neighbours.lookup(room.id, lresp.ExitId); x != nil {
+			banter = fmt.Sprintf("You head %s, to %s.", directionName(lresp.ExitId), exitName(x))
 		}
-		e = SendMessage(conn, req.UserId, j, MTPlayerLocation)
+	}
+	SendMessageToPlayer(sess, banter, req.UserId)
+
+	if validExit {
+		e = SendMessage(sess, req.UserId, lresp)
 	}
 	return
 }
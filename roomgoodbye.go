@@ -7,20 +7,22 @@ package main
 
 import (
 	"fmt"
-	"github.com/gorilla/websocket"
+	"sample-room-golang/gameon/protocol"
 )
 
 // Handles the "good-bye" request that is received each time
 // a player leaves our room.
-func handleGoodbye(conn *websocket.Conn, req *GoodbyeMessage, room string) error {
+func handleGoodbye(sess *Session, req *protocol.Goodbye, room *Room) error {
 	locus := "GOODBYE"
-	checkpoint(locus, fmt.Sprintf("room=%s userid=%s username=%s\n",
-		MyRooms[room], req.UserId, req.Username))
+	checkpoint(locus, fmt.Sprintf("room=%s userid=%s username=%q\n",
+		room.fullName, req.UserId, req.Username))
 
-	UntrackPlayer(room, req.UserId)
+	UntrackPlayer(room.id, req.UserId)
+	sess.unbind(room.id, req.UserId)
+	CancelTimedText(room.id, req.UserId)
 
 	// Announce to the room that the player has left.
-	m := fmt.Sprintf("%s has left %s.", req.Username, config.roomName)
-	BroadcastMessage(room, m, TrackerSender, "*")
+	m := fmt.Sprintf("%s has left %s.", req.Username, room.fullName)
+	BroadcastMessage(room.id, m, TrackerSender, "*")
 	return nil
 }
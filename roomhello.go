@@ -6,22 +6,10 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/gorilla/websocket"
+	"sample-room-golang/gameon/protocol"
 )
 
-type HelloResponse struct {
-	Rtype       string `json:"type,omitempty"`
-	Name        string `json:"name,omitempty"`
-	Description string `json:"description,omitempty"`
-
-	// We have intentially omitted the exits response field
-	// because we do not wish to override our initial exit setup.
-
-	Commands map[string]string `json:"commands,omitempty"`
-}
-
 // Handles the "hello" request that is received each time
 // a player enters our room. The incoming request is a string
 // with the format <room>,<json>, where the JSON string contains
@@ -31,38 +19,39 @@ type HelloResponse struct {
 //   {"version": 1,"username": "DevUser","userId": "dummy.DevUser"}
 //
 // Return an error if a problem occurs, otherwise return nil.
-func handleHello(conn *websocket.Conn, req *HelloMessage, room string) (e error) {
+func handleHello(sess *Session, req *protocol.Hello, room *Room) (e error) {
 	locus := "HELLO"
-	checkpoint(locus, fmt.Sprintf("room=%s version=%d userid=%s username=%s\n",
-		MyRooms[room], req.Version, req.UserId, req.Username))
-
-	if versionSupported(req.Version) {
-		checkpoint(locus, fmt.Sprintf("VERSION=%d is supported.", req.Version))
-	} else {
+	checkpoint(locus, fmt.Sprintf("room=%s version=%d userid=%s username=%q\n",
+		room.fullName, req.Version, req.UserId, req.Username))
+	version, e := sess.negotiate(req.Version)
+	if e != nil {
 		checkpoint(locus, fmt.Sprintf("VERSION=%d is NOT supported.", req.Version))
-		conn.Close()
+		sess.Close()
 		checkpoint(locus, "CONN.CLOSED")
-		e = VersionError{fmt.Sprintf("Version %d is not supported by this room.", req.Version)}
 		return
 	}
-
-	mRoom := fmt.Sprintf("%s has entered %s.", req.Username, MyRooms[room])
-	BroadcastMessage(room, mRoom, TrackerSender, "*")
-
-	pc := PlayerConnection{req.UserId, room, conn}
-	TrackPlayer(&pc)
+	checkpoint(locus, fmt.Sprintf("VERSION=%d is supported.", version))
 
 	mUser := fmt.Sprintf("Welcome to %s, %s. Take your time. Look around.",
-		MyRooms[room], req.Username)
+		room.fullName, req.Username)
+	return welcomePlayer(sess, req.UserId, req.Username, version, room, mUser)
+}
+
+// Tracks a player who has entered (or rejoined) our room, announces
+// their arrival if they are new, greets them with mUser and sends the
+// location response that Game On! requires.
+func welcomePlayer(sess *Session, userId, username string, version int, room *Room, mUser string) (e error) {
+	mRoom := fmt.Sprintf("%s has entered %s.", username, room.fullName)
+	pc := PlayerConnection{playerId: userId, username: username, roomId: room.id, sess: sess, version: version}
+	TrackPlayer(&pc, mRoom)
+	sess.bind(&pc)
 
-	SendMessageToPlayer(conn, mUser, req.UserId)
+	SendMessageToPlayer(sess, mUser, userId)
 
 	// Send back the required response. Do not ignore these errors.
-	var resp HelloResponse
-	var j []byte
-	resp.Rtype = "location"
-	resp.Name = config.roomName
-	resp.Description = fmt.Sprintf("This is %s", MyRooms[room])
+	// (We do not send exits because we do not wish to override our
+	// initial exit setup.)
+	resp := protocol.NewLocation(room.name, room.fullName, room.description)
 
 	// The /help command's output is somewhat canned.  That is, it will
 	// always list a minimal set of commands that the room should respond
@@ -82,20 +71,16 @@ func handleHello(conn *websocket.Conn, req *HelloMessage, room string) (e error)
 	// way, currently, to remove a command from the list that you are choosing not
 	// to support.
 	resp.Commands = make(map[string]string)
-	for _, c := range commandsWeAdd {
+	for _, c := range room.commandsWeAdd {
 		resp.Commands[c.cmd] = c.desc
 	}
-	j, e = json.MarshalIndent(resp, "", "    ")
-	if e != nil {
-		return
-	}
-	e = SendMessage(conn, req.UserId, j, MTPlayer)
+	e = SendMessage(sess, userId, resp)
 	return
 }
 
 func versionSupported(v int) bool {
-	for _, v := range SupportedVersions {
-		if v == v {
+	for _, sv := range SupportedVersions {
+		if sv == v {
 			return true
 		}
 	}
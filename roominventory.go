@@ -5,21 +5,12 @@
 // Room /inventory command
 package main
 
-import (
-	"encoding/json"
-	"github.com/gorilla/websocket"
-	"time"
-)
-
-type InventoryResponse struct {
-	Rtype    string            `json:"type,omitempty"`
-	ExitId   string            `json:"exitId,omitempty"`
-	Content  map[string]string `json:"content,omitempty"`
-	Bookmark int               `json:"bookmark,omitempty"`
-}
+import "sample-room-golang/gameon/protocol"
 
 // TimedText allows us to specify a delay, in milliseconds,
 // before the message containing the string is transmitted.
+// Sequences of TimedText are delivered by the scheduler
+// (scheduler.go) so that the pauses never block roomHandler.
 type TimedText struct {
 	msPause int
 	s       string
@@ -34,23 +25,7 @@ var cheekyInventoryRemarks = []TimedText{
 	{2000, "(Enough, apparently. Your pockets are now empty.)"},
 }
 
-func checkInventory(conn *websocket.Conn, req *GameonRequest, tail, room string) error {
-	for _, tt := range cheekyInventoryRemarks {
-		var resp ExaminationResponse
-		resp.Rtype = "event"
-		resp.Content = make(map[string]string)
-		resp.Content[req.UserId] = tt.s
-		j, err := json.MarshalIndent(resp, "", "    ")
-		if err != nil {
-			return err
-		}
-		if tt.msPause > 0 {
-			time.Sleep(time.Duration(tt.msPause) * time.Millisecond)
-		}
-		err = SendMessage(conn, req.UserId, j, MTPlayer)
-		if err != nil {
-			return err
-		}
-	}
+func checkInventory(sess *Session, req *protocol.Command, tail string, room *Room) error {
+	ScheduleTimedText(sess, room.id, req.UserId, cheekyInventoryRemarks)
 	return nil
 }
@@ -0,0 +1,40 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Room Join (protocol version 2)
+package main
+
+import (
+	"fmt"
+	"sample-room-golang/gameon/protocol"
+)
+
+// Handles the "join" request that a version 2 mediator sends when a
+// player who is already in our room reconnects, for example after
+// their browser session was interrupted. The JSON looks just like
+// that of a hello:
+//
+//	roomJoin,43a4d07399ea23d648568c6d2d000b65,
+//	{"version": 2,"username": "DevUser","userId": "dummy.DevUser"}
+//
+// A rejoining player is not announced to the room again.
+func handleJoin(sess *Session, req *protocol.Join, room *Room) (e error) {
+	locus := "JOIN"
+	checkpoint(locus, fmt.Sprintf("room=%s version=%d userid=%s username=%q\n",
+		room.fullName, req.Version, req.UserId, req.Username))
+	version, e := sess.negotiate(req.Version)
+	if e != nil {
+		checkpoint(locus, fmt.Sprintf("VERSION=%d is NOT supported.", req.Version))
+		sess.Close()
+		checkpoint(locus, "CONN.CLOSED")
+		return
+	}
+	if version < 2 {
+		e = VersionError{fmt.Sprintf("roomJoin requires version 2 but version %d was negotiated.", version)}
+		return
+	}
+
+	mUser := fmt.Sprintf("Welcome back to %s, %s.", room.fullName, req.Username)
+	return welcomePlayer(sess, req.UserId, req.Username, version, room, mUser)
+}
@@ -5,18 +5,7 @@
 // Room /look command
 package main
 
-import (
-	"encoding/json"
-	"github.com/gorilla/websocket"
-	"time"
-)
-
-type LookResponse struct {
-	Rtype    string            `json:"type,omitempty"`
-	ExitId   string            `json:"exitId,omitempty"`
-	Content  map[string]string `json:"content,omitempty"`
-	Bookmark int               `json:"bookmark,omitempty"`
-}
+import "sample-room-golang/gameon/protocol"
 
 // Note that GameOn will strip white space from the ends,
 // so adding spaces for indentation will not currently work.
@@ -27,25 +16,18 @@ var cheekyLookRemarks = []TimedText{
 	{2000, "Looking around is useless in an unlighted room."},
 }
 
-func lookAroundRoom(conn *websocket.Conn, req *GameonRequest, tail, room string) error {
+func lookAroundRoom(sess *Session, req *protocol.Command, tail string, room *Room) error {
 	locus := "LOOK"
 	checkpoint(locus, "AROUND")
-	for _, tt := range cheekyLookRemarks {
-		var resp ExaminationResponse
-		resp.Rtype = "event"
-		resp.Content = make(map[string]string)
-		resp.Content[req.UserId] = tt.s
-		j, err := json.MarshalIndent(resp, "", "    ")
-		if err != nil {
-			return err
-		}
-		if tt.msPause > 0 {
-			time.Sleep(time.Duration(tt.msPause) * time.Millisecond)
-		}
-		err = SendMessage(conn, req.UserId, j, MTPlayer)
-		if err != nil {
-			return err
+	texts := cheekyLookRemarks
+	if doors := neighbours.describe(room.id); len(doors) > 0 {
+		// In the dark the walls, and so the doors, can still be felt.
+		texts = append([]TimedText{}, cheekyLookRemarks...)
+		texts = append(texts, TimedText{1500, "Feeling your way along the walls, you find some doors."})
+		for _, d := range doors {
+			texts = append(texts, TimedText{500, d})
 		}
 	}
+	ScheduleTimedText(sess, room.id, req.UserId, texts)
 	return nil
 }
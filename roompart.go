@@ -0,0 +1,32 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Room Part (protocol version 2)
+package main
+
+import (
+	"fmt"
+	"sample-room-golang/gameon/protocol"
+)
+
+// Handles the "part" request that a version 2 mediator sends when a
+// player's connection to our room goes away although the player has
+// not left. Nothing is announced; the player either rejoins or is
+// forgotten once the player TTL passes (at once if there is none).
+//
+//	roomPart,43a4d07399ea23d648568c6d2d000b65,
+//	{"username": "DevUser","userId": "dummy.DevUser"}
+func handlePart(sess *Session, req *protocol.Part, room *Room) error {
+	locus := "PART"
+	checkpoint(locus, fmt.Sprintf("room=%s userid=%s username=%q\n",
+		room.fullName, req.UserId, req.Username))
+	if v := sess.Version(); v < 2 {
+		return VersionError{fmt.Sprintf("roomPart requires version 2 but version %d was negotiated.", v)}
+	}
+
+	sess.unbind(room.id, req.UserId)
+	CancelTimedText(room.id, req.UserId)
+	PartPlayer(room.id, req.UserId, sess)
+	return nil
+}
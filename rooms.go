@@ -0,0 +1,182 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// The rooms served by this process
+package main
+
+import (
+	"fmt"
+	"sample-room-golang/gameon/protocol"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Every request from the mediator names the site id of the room it
+// is meant for. One process (and one /ws endpoint) may serve several
+// registered sites, so each site id is mapped to a Room that carries
+// its own names, description and command handlers. Requests for a
+// site id that we do not know are refused.
+
+// A CommandHandler carries out one slash command. tail is whatever
+// followed the command word, with surrounding spaces removed.
+type CommandHandler func(sess *Session, req *protocol.Command, tail string, room *Room) error
+
+// A Room is one Game On! site that we serve.
+type Room struct {
+	// The site id assigned by Game On! when the room was registered.
+	id string
+	// The short name we registered and the name players see.
+	name     string
+	fullName string
+	// What players are told when they enter.
+	description string
+	// Slash commands this room responds to, keyed by the upper-case
+	// command word without its slash.
+	commands map[string]CommandHandler
+	// Commands over and above Game On!'s minimal set, which must be
+	// described to the game so that it can list them in /help.
+	commandsWeAdd []CommandDesc
+}
+
+// The commands that every room has unless its manifest entry says
+// otherwise.
+var standardCommands = map[string]CommandHandler{
+	slashDoors:     listDoors,
+	slashExamine:   examineObject,
+	slashGo:        exitRoom,
+	slashInventory: checkInventory,
+	slashLook:      lookAroundRoom,
+	slashWink:      wink,
+}
+
+// Returns a room with the standard set of commands.
+func newRoom(id, name, fullName string) *Room {
+	r := Room{
+		id:            id,
+		name:          name,
+		fullName:      fullName,
+		description:   fmt.Sprintf("This is %s", fullName),
+		commands:      make(map[string]CommandHandler),
+		commandsWeAdd: commandsWeAdd,
+	}
+	for w, h := range standardCommands {
+		r.commands[w] = h
+	}
+	return &r
+}
+
+// Returns the room described by spec, registered as id. See
+// manifest.go.
+func newRoomFromSpec(id string, spec *roomSpec) *Room {
+	r := newRoom(id, spec.Name, spec.FullName)
+	if len(spec.Description) > 0 {
+		r.description = spec.Description
+	}
+	if len(spec.Commands) == 0 {
+		return r
+	}
+	r.commands = make(map[string]CommandHandler)
+	r.commandsWeAdd = nil
+	for _, c := range spec.Commands {
+		w := commandWord(c.Name)
+		if len(c.Response) > 0 {
+			r.commands[w] = respondWith(c.Response)
+		} else {
+			r.commands[w] = standardCommands[w]
+		}
+		desc := c.Description
+		if len(desc) == 0 {
+			// Only the standard commands that Game On! does not
+			// already know about need describing.
+			for _, d := range commandsWeAdd {
+				if commandWord(d.cmd) == w {
+					desc = d.desc
+				}
+			}
+		}
+		if len(desc) > 0 {
+			r.commandsWeAdd = append(r.commandsWeAdd, CommandDesc{"/" + strings.ToLower(w), desc})
+		}
+	}
+	return r
+}
+
+// Returns a command handler that always answers with response.
+func respondWith(response string) CommandHandler {
+	return func(sess *Session, req *protocol.Command, tail string, room *Room) error {
+		return SendMessage(sess, req.UserId, protocol.NewEvent(req.UserId, response))
+	}
+}
+
+// The upper-case command words that room responds to, in a stable
+// order.
+func (r *Room) commandWords() []string {
+	words := make([]string, 0, len(r.commands))
+	for w := range r.commands {
+		words = append(words, w)
+	}
+	sort.Strings(words)
+	return words
+}
+
+// A RoomRouter maps site ids to the rooms that serve them.
+type RoomRouter struct {
+	mu    sync.RWMutex
+	rooms map[string]*Room
+}
+
+var roomRouter = RoomRouter{rooms: make(map[string]*Room)}
+
+// Starts routing requests for r.id to r, replacing any earlier room
+// with the same id.
+func (rr *RoomRouter) Add(r *Room) {
+	rr.mu.Lock()
+	rr.rooms[r.id] = r
+	rr.mu.Unlock()
+}
+
+// Stops routing requests for id.
+func (rr *RoomRouter) Remove(id string) {
+	rr.mu.Lock()
+	delete(rr.rooms, id)
+	rr.mu.Unlock()
+}
+
+// Returns the room for id, or nil if we do not serve it.
+func (rr *RoomRouter) Lookup(id string) *Room {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	return rr.rooms[id]
+}
+
+// Returns every room we serve.
+func (rr *RoomRouter) Rooms() []*Room {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	all := make([]*Room, 0, len(rr.rooms))
+	for _, r := range rr.rooms {
+		all = append(all, r)
+	}
+	return all
+}
+
+// Returns the full name of the room with the given id, or the id
+// itself if we do not serve it.
+func roomFullName(id string) string {
+	if r := roomRouter.Lookup(id); r != nil {
+		return r.fullName
+	}
+	return id
+}
+
+// Tells the sender of a request addressed to a room we do not serve
+// that it went astray. The user id, if any, is fished out of the
+// otherwise unvalidated JSON payload.
+func rejectUnknownRoom(sess *Session, f *protocol.Frame) error {
+	if uid := protocol.PeekUserId(f.Payload); len(uid) > 0 {
+		SendMessageToPlayer(sess, "That room is not served here.", uid)
+	}
+	return PayloadError{fmt.Sprintf("Unknown room id '%s'", f.Target)}
+}
@@ -6,24 +6,11 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/gorilla/websocket"
+	"sample-room-golang/gameon/protocol"
 )
 
-type WinkResponse struct {
-	Rtype   string            `json:"type,omitempty"`
-	Content map[string]string `json:"content,omitempty"`
-}
-
-func wink(conn *websocket.Conn, req *GameonRequest, tail, room string) error {
-	var resp WinkResponse
-	resp.Rtype = "event"
-	resp.Content = make(map[string]string)
-	resp.Content[req.UserId] = fmt.Sprintf("%s winks at you. Slyly.", MyRooms[room])
-	j, err := json.MarshalIndent(resp, "", "    ")
-	if err != nil {
-		return err
-	}
-	return SendMessage(conn, req.UserId, j, MTPlayer)
+func wink(sess *Session, req *protocol.Command, tail string, room *Room) error {
+	text := fmt.Sprintf("%s winks at you. Slyly.", room.fullName)
+	return SendMessage(sess, req.UserId, protocol.NewEvent(req.UserId, text))
 }
@@ -0,0 +1,135 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Timed delivery of multi-part responses
+package main
+
+import (
+	"fmt"
+	"sample-room-golang/gameon/protocol"
+	"sync"
+	"time"
+)
+
+// Some commands (/look, /inventory) answer with a sequence of
+// TimedText entries that are spoken with pauses in between. Sleeping
+// in roomHandler would stall every other player on the connection,
+// so instead the sequence is handed to the scheduler, which keeps a
+// queue per player and delivers each queue from its own goroutine.
+// A player's queue is cancelled when they say goodbye or their
+// connection ends.
+
+// A timedDelivery is one TimedText sequence destined for one player.
+type timedDelivery struct {
+	sess   *Session
+	userId string
+	texts  []TimedText
+}
+
+type playerQueue struct {
+	pending []*timedDelivery
+	// cancel is closed when the queue is abandoned.
+	cancel chan struct{}
+}
+
+type Scheduler struct {
+	mu     sync.Mutex
+	queues map[string]*playerQueue
+}
+
+var scheduler = Scheduler{queues: make(map[string]*playerQueue)}
+
+// Queues texts for delivery to a player. Sequences for the same
+// player are delivered one after the other, in the order in which
+// they were scheduled. ScheduleTimedText never blocks.
+func ScheduleTimedText(sess *Session, roomId, userId string, texts []TimedText) {
+	d := timedDelivery{sess: sess, userId: userId, texts: texts}
+	scheduler.schedule(makePlayerKey(userId, roomId), &d)
+}
+
+// Discards anything still waiting to be delivered to a player.
+func CancelTimedText(roomId, userId string) {
+	scheduler.cancel(makePlayerKey(userId, roomId))
+}
+
+func (s *Scheduler) schedule(key string, d *timedDelivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q := s.queues[key]; q != nil {
+		q.pending = append(q.pending, d)
+		return
+	}
+	q := &playerQueue{pending: []*timedDelivery{d}, cancel: make(chan struct{})}
+	s.queues[key] = q
+	go s.run(key, q)
+}
+
+func (s *Scheduler) cancel(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q := s.queues[key]; q != nil {
+		delete(s.queues, key)
+		close(q.cancel)
+	}
+}
+
+// Cancels q, unless it has already been cancelled and perhaps replaced
+// by a newer queue for the same player, which is left alone.
+func (s *Scheduler) abandon(key string, q *playerQueue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queues[key] == q {
+		delete(s.queues, key)
+		close(q.cancel)
+	}
+}
+
+// Returns the next sequence in q, or nil once q is empty or has been
+// cancelled. An empty queue is removed so that the next call to
+// schedule starts a new goroutine.
+func (s *Scheduler) next(key string, q *playerQueue) *timedDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-q.cancel:
+		return nil
+	default:
+	}
+	if len(q.pending) == 0 {
+		delete(s.queues, key)
+		return nil
+	}
+	d := q.pending[0]
+	q.pending = q.pending[1:]
+	return d
+}
+
+// Delivers everything queued in q.
+func (s *Scheduler) run(key string, q *playerQueue) {
+	locus := "SCHEDULER"
+	for d := s.next(key, q); d != nil; d = s.next(key, q) {
+		for _, tt := range d.texts {
+			if tt.msPause > 0 {
+				t := time.NewTimer(time.Duration(tt.msPause) * time.Millisecond)
+				select {
+				case <-t.C:
+				case <-q.cancel:
+					t.Stop()
+					checkpoint(locus, fmt.Sprintf("CANCELLED key=%s", key))
+					return
+				}
+			}
+			err := sendTimedText(d, tt)
+			if err != nil {
+				checkpoint(locus, fmt.Sprintf("FAILED key=%s err=%s", key, err.Error()))
+				s.abandon(key, q)
+				return
+			}
+		}
+	}
+}
+
+func sendTimedText(d *timedDelivery, tt TimedText) error {
+	return SendMessage(d.sess, d.userId, protocol.NewEvent(d.userId, tt.s))
+}
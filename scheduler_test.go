@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Waits up to a second for n messages to be queued on s.
+func waitQueued(s *Session, n int) []string {
+	var all []string
+	deadline := time.Now().Add(time.Second)
+	for len(all) < n && time.Now().Before(deadline) {
+		all = append(all, queued(s)...)
+		time.Sleep(time.Millisecond)
+	}
+	return all
+}
+
+func TestScheduleTimedText(t *testing.T) {
+	const room, uid = "scheduler.room", "dummy.Reader"
+	sess := queueSession(16)
+	ScheduleTimedText(sess, room, uid, []TimedText{{0, "one"}, {10, "two"}})
+	ScheduleTimedText(sess, room, uid, []TimedText{{10, "three"}})
+	got := waitQueued(sess, 3)
+	if len(got) != 3 {
+		t.Fatalf("Delivered %q", got)
+	}
+	for i, want := range []string{"one", "two", "three"} {
+		if !strings.Contains(got[i], want) {
+			t.Errorf("Message %d was %q, want %q", i+1, got[i], want)
+		}
+	}
+
+	// Cancelling discards what has not been delivered yet.
+	ScheduleTimedText(sess, room, uid, []TimedText{{0, "four"}, {50, "five"}})
+	waitQueued(sess, 1)
+	CancelTimedText(room, uid)
+	time.Sleep(100 * time.Millisecond)
+	if got := queued(sess); len(got) != 0 {
+		t.Errorf("After cancelling delivered %q", got)
+	}
+}
+
+func TestAbandonLeavesNewerQueue(t *testing.T) {
+	s := Scheduler{queues: make(map[string]*playerQueue)}
+	old := &playerQueue{cancel: make(chan struct{})}
+	newer := &playerQueue{cancel: make(chan struct{})}
+	// old was cancelled and newer scheduled before old's send failed.
+	s.queues["k"] = newer
+	s.abandon("k", old)
+	select {
+	case <-newer.cancel:
+		t.Errorf("Abandoning an old queue cancelled its replacement.")
+	default:
+	}
+	if s.queues["k"] != newer {
+		t.Errorf("Abandoning an old queue removed its replacement.")
+	}
+	s.abandon("k", newer)
+	if _, ok := s.queues["k"]; ok {
+		t.Errorf("A queue was not removed when abandoned.")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// The shared secret
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Our shared secret signs everything we send to the map service and,
+// unless we registered a connection token, the mediator's websocket
+// handshake. A secret given with -secret shows up in ps, so it may
+// instead be given in the GAMEON_SECRET environment variable or, best
+// of all, in a file named by -secretFile, such as a mounted Kubernetes
+// Secret. Every -secretPoll seconds we look at the file's modification
+// time and size and, if either has changed, read it again. After such
+// a rotation handshakes signed with the previous secret are still
+// accepted for -secretGrace seconds, since the mediator may not have
+// caught up yet; everything we sign uses the new secret at once.
+//
+// The secret itself is never logged.
+
+// The environment variable that holds our secret if neither -secret
+// nor -secretFile is given.
+const secretEnv = "GAMEON_SECRET"
+
+// A secretKeeper holds the secret read from -secretFile and the one it
+// replaced.
+type secretKeeper struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	current string
+	// The secret current replaced, and when it stops being accepted.
+	previous      string
+	previousUntil time.Time
+}
+
+var sharedSecrets secretKeeper
+
+// Returns the secret with which to sign: the one most recently read
+// from -secretFile, or config.secret if there is no such file.
+func sharedSecret() string {
+	sharedSecrets.mu.Lock()
+	defer sharedSecrets.mu.Unlock()
+	if len(sharedSecrets.current) > 0 {
+		return sharedSecrets.current
+	}
+	return config.secret
+}
+
+// Returns the secrets with which a handshake may be signed at now: our
+// current secret and, during the grace period after a rotation, the
+// previous one.
+func handshakeSecrets(now time.Time) []string {
+	sharedSecrets.mu.Lock()
+	defer sharedSecrets.mu.Unlock()
+	if len(sharedSecrets.current) == 0 {
+		return []string{config.secret}
+	}
+	keys := []string{sharedSecrets.current}
+	if len(sharedSecrets.previous) > 0 && now.Before(sharedSecrets.previousUntil) {
+		keys = append(keys, sharedSecrets.previous)
+	}
+	return keys
+}
+
+// Settles where our secret comes from: -secretFile, else -secret if
+// it was given, else $GAMEON_SECRET, else the -secret default.
+func loadSecret() error {
+	given := flagGiven("secret")
+	if config.secretPoll < 0 || config.secretGrace < 0 {
+		return ArgError{"secretPoll and secretGrace must not be negative."}
+	}
+	if len(config.secretFile) > 0 {
+		if given {
+			return ArgError{"Give only one of -secret and -secretFile."}
+		}
+		return sharedSecrets.load(config.secretFile)
+	}
+	if env := os.Getenv(secretEnv); !given && len(env) > 0 {
+		config.secret = env
+	}
+	return nil
+}
+
+// Reads our secret from path. Unlike reload, fails if it cannot.
+func (k *secretKeeper) load(path string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.path = path
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	secret, err := readSecret(path)
+	if err != nil {
+		return err
+	}
+	k.current, k.modTime, k.size = secret, info.ModTime(), info.Size()
+	return nil
+}
+
+// Reads the secret file again if it has changed since we last read it.
+// A new secret becomes current at once and the old one is kept until
+// now plus grace. Returns true if the secret changed. On error we keep
+// the secret we have.
+func (k *secretKeeper) reload(now time.Time, grace time.Duration) (rotated bool, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return
+	}
+	if info.ModTime().Equal(k.modTime) && info.Size() == k.size {
+		return
+	}
+	secret, err := readSecret(k.path)
+	if err != nil {
+		return
+	}
+	k.modTime, k.size = info.ModTime(), info.Size()
+	if secret == k.current {
+		return
+	}
+	k.previous, k.previousUntil = k.current, now.Add(grace)
+	k.current = secret
+	return true, nil
+}
+
+// Returns the contents of path without surrounding white space, which
+// must not be empty.
+func readSecret(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(b))
+	if len(secret) == 0 {
+		return "", ArgError{fmt.Sprintf("Secret file %s is empty.", path)}
+	}
+	return secret, nil
+}
+
+// Watches -secretFile for a new secret until stop is closed.
+func WatchSecret(stop <-chan struct{}) {
+	locus := "SECRET"
+	if len(config.secretFile) == 0 || config.secretPoll == 0 {
+		return
+	}
+	grace := time.Duration(config.secretGrace) * time.Second
+	for pause(time.Duration(config.secretPoll)*time.Second, stop) {
+		rotated, err := sharedSecrets.reload(time.Now(), grace)
+		if err != nil {
+			checkpoint(locus, fmt.Sprintf("RELOAD.FAILED %s, keeping the secret we have", err.Error()))
+			continue
+		}
+		if rotated {
+			secretRotations.Inc()
+			checkpoint(locus, fmt.Sprintf("ROTATED from %s; the previous secret is accepted for %v",
+				config.secretFile, grace))
+		}
+	}
+}
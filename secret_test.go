@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSecretRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		sharedSecrets.mu.Lock()
+		sharedSecrets.path, sharedSecrets.current, sharedSecrets.previous = "", "", ""
+		sharedSecrets.mu.Unlock()
+	}()
+	config.handshakeSkew = 60
+	path := filepath.Join(dir, "secret")
+	write := func(secret string, age time.Duration) {
+		if err := ioutil.WriteFile(path, []byte(secret), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	write("first-secret\n", time.Hour)
+	if err := sharedSecrets.load(path); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if s := sharedSecret(); s != "first-secret" {
+		t.Errorf("Loaded %q", s)
+	}
+	now := time.Now()
+	if rotated, err := sharedSecrets.reload(now, time.Minute); rotated || err != nil {
+		t.Errorf("An unchanged file was read again: %v, %v", rotated, err)
+	}
+
+	write("", time.Minute)
+	if _, err := sharedSecrets.reload(now, time.Minute); err == nil || sharedSecret() != "first-secret" {
+		t.Errorf("An empty secret file was accepted: %v", err)
+	}
+
+	write("second-secret", 0)
+	if rotated, err := sharedSecrets.reload(now, time.Minute); !rotated || err != nil {
+		t.Fatalf("The new secret was not read: %v, %v", rotated, err)
+	}
+	if s := sharedSecret(); s != "second-secret" {
+		t.Errorf("Signing with %q after the rotation", s)
+	}
+	if keys := handshakeSecrets(now); len(keys) != 2 {
+		t.Errorf("Expected both secrets during the grace period, got %d", len(keys))
+	}
+	if keys := handshakeSecrets(now.Add(2 * time.Minute)); len(keys) != 1 || keys[0] != "second-secret" {
+		t.Errorf("Expected only the new secret after the grace period")
+	}
+
+	for _, secret := range []string{"first-secret", "second-secret"} {
+		date := time.Now().UTC().Format(time.RFC1123)
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Header.Set("gameon-date", date)
+		req.Header.Set("gameon-signature", buildHmac([]string{date}, secret))
+		if err := verifyHandshake(req); err != nil {
+			t.Errorf("A handshake signed with %s was rejected during the grace period: %v", secret, err)
+		}
+	}
+}
@@ -14,7 +14,6 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
-	"crypto/tls"
 	"fmt"
 	"os"
 )
@@ -72,7 +71,8 @@ func RequestTracker(counter *prometheus.CounterVec) gin.HandlerFunc {
 // }
 
 func main() {
-	log.SetFormatter(&log.JSONFormatter{})
+	redaction = newRedactor(&log.JSONFormatter{})
+	log.SetFormatter(redaction)
 	log.SetOutput(os.Stdout)
 
 	// Adding Route Counter via Prometheus Metrics
@@ -83,6 +83,7 @@ func main() {
 		Help:      "Number of requests received",
 	}, []string{"Route", "Method"})
 	prometheus.MustRegister(counter)
+	registerMetrics()
 
 	// Hystrix configuration
 	// hystrix.ConfigureCommand("timeout", hystrix.CommandConfig{
@@ -132,10 +133,12 @@ func main() {
 	// router.Use(OpenTracing())
 	// router.Use(HystrixHandler("timeout"))
 
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/metrics", allowFrom(&metricsAccess), gin.WrapH(promhttp.Handler()))
 
 	router.Use(static.Serve("/", static.LocalFile("./public", false)))
-	router.GET("/health", routers.HealthGET)
+	router.GET("/health", allowFrom(&healthAccess), routers.HealthGET)
+	// Admin routes.
+	router.GET("/status/registration", allowFrom(&adminAccess), RegistrationStatusGET)
 
 	locus := "MAIN"
 	checkpoint(locus, "processCommandLine")
@@ -145,24 +148,40 @@ func main() {
 		flag.Usage()
 		return
 	}
+	if config.invocation != nil {
+		// Keep our log out of the subcommand's output.
+		log.SetOutput(os.Stderr)
+	}
 	printConfig(&config)
-
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	if config.insecure {
+		log.Warnln("WARNING: -insecure is set, so the map service's certificate is NOT checked. " +
+			"Our signed requests can be read and replayed by anyone in between. Never use -insecure in production.")
 	}
+
+	tr := &http.Transport{TLSClientConfig: config.mapTLS}
 	client := &http.Client{Transport: tr}
+	mc := newMapClient(client)
 
+	if config.invocation != nil {
+		err = runSubcommand(mc, config.invocation)
+		if err != nil {
+			log.Errorln(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 	if len(config.roomToDelete) > 0 {
 		checkpoint(locus, fmt.Sprintf("deleteWithRetries %s", config.roomToDelete))
-		err = deleteWithRetries(client, config.roomToDelete)
+		err = deleteWithRetries(mc, config.roomToDelete)
 		if err != nil {
 			checkpoint(locus, fmt.Sprintf("DELETE.FAILED err=%s", err.Error()))
 		}
 		return
 	}
 
+	go WatchSecret(conversationStop)
 	checkpoint(locus, "registerWithRetries")
-	err = registerWithRetries(client)
+	err = registerWithRetries(mc)
 	if err != nil {
 		log.Errorln(err.Error())
 		return
@@ -171,13 +190,28 @@ func main() {
 
 	locus = "WS.SERVER"
 	go TrackPlayers()
-	go InjectConversations()
-	checkpoint(locus, fmt.Sprintf("Listening to port %d", config.listeningPort))
+	go InjectConversations(conversationStop)
+	go WatchRegistrations(mc)
+	go RefreshExits(mc, conversationStop)
+	go WatchCertificate(conversationStop)
+	if config.serveTLS != nil {
+		checkpoint(locus, fmt.Sprintf("Listening to port %d with TLS", config.listeningPort))
+	} else {
+		checkpoint(locus, fmt.Sprintf("Listening to port %d", config.listeningPort))
+	}
 	router.GET("/ws", func(c *gin.Context) {
 		log.Println("Got something...")
+		if isShuttingDown() {
+			c.String(http.StatusServiceUnavailable, "The room is closing.")
+			return
+		}
 		roomHandler(c.Writer, c.Request)
 	})
-	router.Run(port())
+	srv := &http.Server{Addr: port(), Handler: router, TLSConfig: config.serveTLS}
+	err = serveUntilSignalled(srv, mc)
+	if err != nil {
+		log.Errorln(err.Error())
+	}
 }
 
 // Prints a simple checkpoint message.
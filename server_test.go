@@ -5,7 +5,7 @@ import (
   "net/http/httptest"
   "net/http" // Do we need this import?
   "github.com/gin-gonic/gin"
-  "sampleroomgolangnew/routers"
+  "sample-room-golang/routers"
 )
 
 func TestServer(t *testing.T) {
@@ -0,0 +1,369 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Per-connection sessions
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A websocket connection may only have one concurrent writer, but we
+// send to players from the read loop in roomHandler and from the
+// tracker goroutine (broadcasts and smalltalk). A Session owns the
+// connection and serializes every outbound message through a bounded
+// queue that is drained by a single writer goroutine.
+//
+// The writer also pings the mediator every pingPeriod(). Every pong
+// or message we read pushes the read deadline back, so a mediator
+// that silently goes away causes the read in roomHandler to fail
+// and the session to end. Players that were bound to the session
+// are then untracked and their departure is announced.
+
+const (
+	// What to do when a session's outbound queue is full.
+	// Drop the message that would not fit.
+	SlowConsumerDrop = "drop"
+	// Wait until there is room in the queue or the session closes.
+	SlowConsumerBlock = "block"
+	// Close the session; the mediator will reconnect.
+	SlowConsumerDisconnect = "disconnect"
+)
+
+var slowConsumerPolicies = []string{SlowConsumerDrop, SlowConsumerBlock, SlowConsumerDisconnect}
+
+type Session struct {
+	// lastActivity is the UnixNano time of the last message that we
+	// read. It is written by the reader and read by the writer. It
+	// comes first so that it is 64-bit aligned for sync/atomic.
+	lastActivity int64
+	conn         *websocket.Conn
+	outbound     chan []byte
+	policy       string
+	// done is closed exactly once, by Close, to stop the writer.
+	done      chan struct{}
+	closeOnce sync.Once
+	// draining is closed by Drain or Reject to ask the writer to flush the
+	// queue and say goodbye; finished is closed when the writer exits.
+	draining  chan struct{}
+	drainOnce sync.Once
+	finished  chan struct{}
+	// Players who said hello over this connection and have not yet
+	// said goodbye, and the protocol version the mediator chose.
+	mu      sync.Mutex
+	players map[string]*PlayerConnection
+	version int
+	// The close frame sent once the queue has been flushed. Set
+	// before draining is closed.
+	closeCode int
+	closeText string
+	// The number of malformed messages read so far. Only the reader
+	// touches this.
+	malformed int
+}
+
+// Wraps conn in a new Session and starts its writer goroutine.
+func NewSession(conn *websocket.Conn) *Session {
+	s := &Session{
+		conn:     conn,
+		outbound: make(chan []byte, config.sendQueueSize),
+		policy:   config.slowConsumer,
+		done:     make(chan struct{}),
+		draining: make(chan struct{}),
+		finished: make(chan struct{}),
+		players:  make(map[string]*PlayerConnection),
+	}
+	liveSessions.add(s)
+	s.receivedMessage()
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait()))
+	})
+	go s.writer()
+	return s
+}
+
+// Called by the reader each time a message arrives. Pushes back the
+// read deadline and resets the idle timer.
+func (s *Session) receivedMessage() {
+	now := time.Now()
+	atomic.StoreInt64(&s.lastActivity, now.UnixNano())
+	s.conn.SetReadDeadline(now.Add(pongWait()))
+}
+
+// Asks the writer to deliver whatever is still queued and then close
+// the connection with a going-away frame. If that has not happened
+// within timeout the session is closed regardless.
+func (s *Session) Drain(timeout time.Duration) {
+	s.closeWith(websocket.CloseGoingAway, "The room is closing.", timeout)
+}
+
+// Gives up on a connection that is misbehaving: whatever is queued is
+// delivered, followed by a close frame with the given code and text.
+func (s *Session) Reject(code int, text string) {
+	s.closeWith(code, text, writeWait())
+}
+
+// Flushes the queue, closes the connection with code and text, and
+// waits up to timeout for that to happen before closing regardless.
+func (s *Session) closeWith(code int, text string, timeout time.Duration) {
+	s.drainOnce.Do(func() {
+		s.closeCode = code
+		s.closeText = text
+		close(s.draining)
+	})
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-s.finished:
+	case <-t.C:
+		checkpoint("SESSION.DRAIN", "TIMED.OUT")
+	}
+	s.Close()
+}
+
+// Returns true once the session has been closed.
+func (s *Session) Closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Remembers that pc's player is using this session.
+func (s *Session) bind(pc *PlayerConnection) {
+	s.mu.Lock()
+	s.players[makePlayerKey(pc.playerId, pc.roomId)] = pc
+	s.mu.Unlock()
+}
+
+// Forgets a player who has left the room.
+func (s *Session) unbind(roomId, playerId string) {
+	s.mu.Lock()
+	delete(s.players, makePlayerKey(playerId, roomId))
+	s.mu.Unlock()
+}
+
+// Deals with the players still bound to this session once the
+// connection has ended. Version 1 has no way to say "I'll be back",
+// so version 1 players are untracked and their departure announced.
+// Version 2 players have merely parted and may rejoin.
+func (s *Session) departAll() {
+	s.mu.Lock()
+	players := s.players
+	s.players = make(map[string]*PlayerConnection)
+	s.mu.Unlock()
+	for _, pc := range players {
+		CancelTimedText(pc.roomId, pc.playerId)
+		if pc.version >= 2 {
+			PartPlayer(pc.roomId, pc.playerId, s)
+		} else {
+			untrackDeparted(pc)
+		}
+	}
+}
+
+// Records the protocol version announced by the mediator. Every
+// player on a connection must use the version negotiated by the
+// first one.
+func (s *Session) negotiate(v int) (version int, err error) {
+	if v == 0 {
+		// Early mediators did not always send a version.
+		v = 1
+	}
+	if !versionSupported(v) {
+		err = VersionError{fmt.Sprintf("Version %d is not supported by this room.", v)}
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.version == 0 {
+		s.version = v
+	}
+	if s.version != v {
+		err = VersionError{fmt.Sprintf("Version %d was requested but version %d was negotiated.", v, s.version)}
+		return
+	}
+	version = v
+	return
+}
+
+// Returns the negotiated protocol version, or 0 if none has been.
+func (s *Session) Version() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version
+}
+
+// Queues m for delivery to the mediator. If the outbound queue is
+// full the session's slow consumer policy decides whether we drop m,
+// wait for room, or give up on the connection altogether.
+func (s *Session) Send(m []byte) error {
+	select {
+	case <-s.done:
+		return SessionError{"Session is closed."}
+	default:
+	}
+	if s.policy == SlowConsumerBlock {
+		select {
+		case s.outbound <- m:
+			return nil
+		case <-s.done:
+			return SessionError{"Session closed while waiting to send."}
+		}
+	}
+	select {
+	case s.outbound <- m:
+		return nil
+	default:
+	}
+	if s.policy == SlowConsumerDisconnect {
+		checkpoint("SESSION", "SLOW.CONSUMER disconnecting")
+		s.Close()
+		return SessionError{"Outbound queue is full. Disconnected."}
+	}
+	return SessionError{fmt.Sprintf("Outbound queue is full. Dropped %d bytes.", len(m))}
+}
+
+// Stops the writer and closes the underlying connection. It is safe
+// to call Close more than once and from any goroutine.
+func (s *Session) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// Drains the outbound queue. This is the only goroutine that writes
+// data frames to s.conn.
+func (s *Session) writer() {
+	locus := "SESSION.WRITER"
+	ticker := time.NewTicker(pingPeriod())
+	defer func() {
+		ticker.Stop()
+		s.conn.Close()
+		liveSessions.remove(s)
+		close(s.finished)
+	}()
+	for {
+		select {
+		case m := <-s.outbound:
+			s.conn.SetWriteDeadline(time.Now().Add(writeWait()))
+			err := s.conn.WriteMessage(ExpectedMessageType, m)
+			if err != nil {
+				checkpoint(locus, fmt.Sprintf("FAILED err=%s", err.Error()))
+				s.Close()
+				return
+			}
+		case <-ticker.C:
+			if s.idle() {
+				checkpoint(locus, "IDLE.TIMEOUT")
+				s.Close()
+				return
+			}
+			s.conn.SetWriteDeadline(time.Now().Add(writeWait()))
+			err := s.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				checkpoint(locus, fmt.Sprintf("PING.FAILED err=%s", err.Error()))
+				s.Close()
+				return
+			}
+		case <-s.draining:
+			s.flush()
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Writes everything left in the outbound queue followed by a close
+// frame. Called by the writer when the session is being drained.
+func (s *Session) flush() {
+	locus := "SESSION.FLUSH"
+	for {
+		select {
+		case m := <-s.outbound:
+			s.conn.SetWriteDeadline(time.Now().Add(writeWait()))
+			err := s.conn.WriteMessage(ExpectedMessageType, m)
+			if err != nil {
+				checkpoint(locus, fmt.Sprintf("FAILED err=%s", err.Error()))
+				return
+			}
+		default:
+			cm := websocket.FormatCloseMessage(s.closeCode, s.closeText)
+			s.conn.WriteControl(websocket.CloseMessage, cm, time.Now().Add(writeWait()))
+			return
+		}
+	}
+}
+
+// Returns true if nothing but pongs has arrived for longer than
+// the configured idle timeout.
+func (s *Session) idle() bool {
+	if config.idleTimeout <= 0 {
+		return false
+	}
+	last := time.Unix(0, atomic.LoadInt64(&s.lastActivity))
+	return time.Since(last) > time.Duration(config.idleTimeout)*time.Second
+}
+
+// How long we wait for a pong (or any message) before giving up.
+func pongWait() time.Duration {
+	return time.Duration(config.pongWait) * time.Second
+}
+
+// How often we ping. This must be less than pongWait.
+func pingPeriod() time.Duration {
+	return pongWait() * 9 / 10
+}
+
+// How long a single write may take.
+func writeWait() time.Duration {
+	return time.Duration(config.writeWait) * time.Second
+}
+
+func isSlowConsumerPolicy(p string) bool {
+	for _, known := range slowConsumerPolicies {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+// The set of sessions whose writer is still running, so that we can
+// drain them all when we shut down.
+type sessionSet struct {
+	mu   sync.Mutex
+	live map[*Session]bool
+}
+
+var liveSessions = sessionSet{live: make(map[*Session]bool)}
+
+func (ss *sessionSet) add(s *Session) {
+	ss.mu.Lock()
+	ss.live[s] = true
+	ss.mu.Unlock()
+}
+
+func (ss *sessionSet) remove(s *Session) {
+	ss.mu.Lock()
+	delete(ss.live, s)
+	ss.mu.Unlock()
+}
+
+func (ss *sessionSet) all() []*Session {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	all := make([]*Session, 0, len(ss.live))
+	for s := range ss.live {
+		all = append(all, s)
+	}
+	return all
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a session with no connection whose outbound queue holds up
+// to n messages, for tests that only look at what is sent.
+func queueSession(n int) *Session {
+	return &Session{
+		outbound: make(chan []byte, n),
+		policy:   SlowConsumerDrop,
+		done:     make(chan struct{}),
+		players:  make(map[string]*PlayerConnection),
+	}
+}
+
+// Returns and empties what has been queued on s.
+func queued(s *Session) []string {
+	var all []string
+	for {
+		select {
+		case m := <-s.outbound:
+			all = append(all, string(m))
+		default:
+			return all
+		}
+	}
+}
+
+// Returns a Session for the server end of a real websocket and the
+// client end.
+func sessionPair(t *testing.T) (*Session, *websocket.Conn, func()) {
+	sessions := make(chan *Session, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		sessions <- NewSession(conn)
+	}))
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("Dial failed: %v", err)
+	}
+	sess := <-sessions
+	return sess, client, func() {
+		sess.Close()
+		client.Close()
+		srv.Close()
+	}
+}
+
+func TestSlowConsumerPolicies(t *testing.T) {
+	// Nothing drains these queues, so the third message finds them full.
+	drop := queueSession(2)
+	for i := 0; i < 2; i++ {
+		if err := drop.Send([]byte("m")); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+	if err := drop.Send([]byte("m")); err == nil {
+		t.Errorf("A message that did not fit was queued.")
+	}
+	if drop.Closed() || len(queued(drop)) != 2 {
+		t.Errorf("Dropping a message closed the session or lost the queue.")
+	}
+
+	disconnect := queueSession(1)
+	disconnect.policy = SlowConsumerDisconnect
+	disconnect.Send([]byte("m"))
+	if err := disconnect.Send([]byte("m")); err == nil || !disconnect.Closed() {
+		t.Errorf("A full queue did not disconnect: %v", err)
+	}
+	if err := disconnect.Send([]byte("m")); err == nil {
+		t.Errorf("A closed session accepted a message.")
+	}
+
+	block := queueSession(1)
+	block.policy = SlowConsumerBlock
+	block.Send([]byte("m"))
+	sent := make(chan error)
+	go func() { sent <- block.Send([]byte("m")) }()
+	select {
+	case err := <-sent:
+		t.Fatalf("Send did not wait for room: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	<-block.outbound
+	if err := <-sent; err != nil {
+		t.Errorf("A blocked send failed once there was room: %v", err)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	s := queueSession(1)
+	if v, err := s.negotiate(2); err != nil || v != 2 {
+		t.Errorf("negotiate(2) = %d, %v", v, err)
+	}
+	// Every later player on the connection must use the same version.
+	if _, err := s.negotiate(1); err == nil {
+		t.Errorf("A second version was accepted on the same connection.")
+	}
+	if v, err := s.negotiate(2); err != nil || v != 2 || s.Version() != 2 {
+		t.Errorf("negotiate(2) again = %d, %v", v, err)
+	}
+
+	// Early mediators sent no version at all, which means 1.
+	if v, err := queueSession(1).negotiate(0); err != nil || v != 1 {
+		t.Errorf("negotiate(0) = %d, %v", v, err)
+	}
+	if _, err := queueSession(1).negotiate(3); err == nil {
+		t.Errorf("An unsupported version was accepted.")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	config.sendQueueSize, config.slowConsumer = 8, SlowConsumerDrop
+	config.pongWait, config.writeWait = 60, 10
+	sess, client, done := sessionPair(t)
+	defer done()
+
+	// What is queued is delivered before the going-away close.
+	sess.Send([]byte("one"))
+	sess.Send([]byte("two"))
+	sess.Drain(time.Second)
+	for _, want := range []string{"one", "two"} {
+		if _, b, err := client.ReadMessage(); err != nil || string(b) != want {
+			t.Errorf("Read %q, %v; want %q", b, err, want)
+		}
+	}
+	_, _, err := client.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("After draining read %v, want a going-away close", err)
+	}
+	if !sess.Closed() {
+		t.Errorf("The drained session is not closed.")
+	}
+
+	// A writer that never finishes is given up on after the timeout.
+	stuck := queueSession(1)
+	stuck.draining, stuck.finished = make(chan struct{}), make(chan struct{})
+	start := time.Now()
+	stuck.Drain(50 * time.Millisecond)
+	if d := time.Since(start); d < 50*time.Millisecond || d > time.Second {
+		t.Errorf("Drain gave up after %v", d)
+	}
+	if !stuck.Closed() {
+		t.Errorf("A session whose drain timed out is not closed.")
+	}
+}
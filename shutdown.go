@@ -0,0 +1,111 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Graceful shutdown
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sample-room-golang/gameon/mapclient"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// When we are asked to stop (SIGTERM during a rollout, or ^C) we
+// refuse new websocket upgrades, tell everyone in the room that it
+// is closing (optionally walking them out through -shutdownExit),
+// give each session until -drainSeconds to deliver what it has
+// queued, stop the registration watchdog, optionally delete our
+// registrations, and only then stop the HTTP server.
+
+// Non-zero once shutdown has begun. Only access with sync/atomic.
+var shuttingDown int32
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) != 0
+}
+
+// Closed to stop the goroutines that inject chatter into the room and
+// refresh its exits.
+var conversationStop = make(chan struct{})
+
+// Serves srv until it fails or we receive SIGTERM or SIGINT, in which
+// case the room is shut down gracefully. Returns the server's error
+// if it failed, otherwise nil.
+func serveUntilSignalled(srv *http.Server, mc *mapclient.Client) error {
+	locus := "SERVE"
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	failed := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			// The certificate comes from srv.TLSConfig.GetCertificate.
+			failed <- srv.ListenAndServeTLS("", "")
+		} else {
+			failed <- srv.ListenAndServe()
+		}
+	}()
+	select {
+	case err := <-failed:
+		return err
+	case sig := <-signals:
+		checkpoint(locus, fmt.Sprintf("SIGNAL %v", sig))
+		shutdown(srv, mc)
+		return nil
+	}
+}
+
+// Shuts the room down. See the comment at the top of this file.
+func shutdown(srv *http.Server, mc *mapclient.Client) {
+	locus := "SHUTDOWN"
+	deadline := time.Now().Add(time.Duration(config.drainSeconds) * time.Second)
+	atomic.StoreInt32(&shuttingDown, 1)
+	close(conversationStop)
+
+	checkpoint(locus, "EVICTING players")
+	m := "%s is closing. Please come back later."
+	if len(config.shutdownExit) > 0 {
+		m = "%s is closing. You are shown the way out."
+	}
+	EvictAllPlayers(m, config.shutdownExit)
+
+	sessions := liveSessions.all()
+	checkpoint(locus, fmt.Sprintf("DRAINING %d sessions", len(sessions)))
+	var wg sync.WaitGroup
+	for _, s := range sessions {
+		wg.Add(1)
+		go func(s *Session) {
+			defer wg.Done()
+			s.Drain(time.Until(deadline))
+		}(s)
+	}
+	wg.Wait()
+
+	checkpoint(locus, "STOPPING watchdog")
+	stopWatchdog(deadline)
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if config.deregister {
+		for _, r := range roomRouter.Rooms() {
+			checkpoint(locus, fmt.Sprintf("DEREGISTERING %s", r.id))
+			err := deleteRoom(ctx, mc, r.id)
+			if err != nil {
+				checkpoint(locus, fmt.Sprintf("DEREGISTER.FAILED err=%s", err.Error()))
+			}
+		}
+	}
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		checkpoint(locus, fmt.Sprintf("HTTP.SHUTDOWN err=%s", err.Error()))
+	}
+	checkpoint(locus, "DONE")
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Connection tokens
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sample-room-golang/gameon/mapclient"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Game On! lets a room register a token in its connectionDetails.
+// When one is registered the mediator signs its websocket handshake
+// with the token rather than with the owner's shared secret, so a
+// room need not hold the secret just to check who is calling.
+//
+// With -tokenFile we read the token from that file, or generate one
+// and write it there if the file does not exist yet, so that the
+// token survives restarts and matches what we registered.
+//
+// Until the token is registered the mediator still signs with the
+// shared secret, and the map service may not show us a registered
+// token, so we cannot tell from a lookup whether it is there. So we
+// remember, for each room, the token that a registration or update of
+// ours carried and the revision of the site that it produced, and go
+// on accepting the shared secret until every room's registration is
+// known to carry our token.
+
+// The number of random bytes in a generated token.
+const tokenBytes = 32
+
+// Loads our connection token from path, creating it if need be.
+func loadOrCreateToken(path string) (token string, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		token = strings.TrimSpace(string(b))
+		if len(token) == 0 {
+			err = ArgError{fmt.Sprintf("Token file %s is empty.", path)}
+		}
+		return
+	}
+	if !os.IsNotExist(err) {
+		return
+	}
+	raw := make([]byte, tokenBytes)
+	_, err = rand.Read(raw)
+	if err != nil {
+		return
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	err = ioutil.WriteFile(path, []byte(token+"\n"), 0600)
+	if err == nil {
+		checkpoint("TOKEN", fmt.Sprintf("GENERATED %s", path))
+	}
+	return
+}
+
+// A registeredToken is the token that a registration of ours carried
+// and the site, at the revision, that it produced.
+type registeredToken struct {
+	id    string
+	rev   string
+	token string
+}
+
+// The tokens we registered, by room name. Only use while holding
+// registeredTokensMu.
+var (
+	registeredTokens   = make(map[string]registeredToken)
+	registeredTokensMu sync.Mutex
+)
+
+// Records that site, the registration of spec that the map service
+// returned after we sent it, carries token.
+func noteRegisteredToken(spec *roomSpec, site *mapclient.Site, token string) {
+	if site == nil || len(token) == 0 {
+		return
+	}
+	registeredTokensMu.Lock()
+	defer registeredTokensMu.Unlock()
+	registeredTokens[spec.Name] = registeredToken{site.Id, site.Rev, token}
+}
+
+// Reports whether site, the registration of spec, is known to carry
+// our token: either it shows it, or it is the very revision that an
+// update of ours carrying the token produced.
+func tokenConfirmed(spec *roomSpec, site *mapclient.Site) bool {
+	if len(config.token) == 0 || site == nil || site.Info == nil {
+		return false
+	}
+	if c := site.Info.ConnectionDetails; c != nil && c.Token == config.token {
+		noteRegisteredToken(spec, site, config.token)
+		return true
+	}
+	registeredTokensMu.Lock()
+	defer registeredTokensMu.Unlock()
+	r := registeredTokens[spec.Name]
+	return r.id == site.Id && r.rev == site.Rev && r.token == config.token
+}
+
+// Reports whether every one of our rooms is known to be registered
+// with our token.
+func tokenRegistered() bool {
+	if len(config.token) == 0 {
+		return false
+	}
+	registeredTokensMu.Lock()
+	defer registeredTokensMu.Unlock()
+	for _, spec := range roomSpecs {
+		if registeredTokens[spec.Name].token != config.token {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the key with which the mediator signs its handshake: our
+// token once it is registered, otherwise our shared secret.
+func handshakeKey() string {
+	if tokenRegistered() {
+		return config.token
+	}
+	return sharedSecret()
+}
+
+// Returns every key with which a handshake may be signed at now: our
+// token, and, until it is registered, our shared secret and, just
+// after a rotation, the previous one (see secret.go).
+func handshakeKeys(now time.Time) []string {
+	if tokenRegistered() {
+		return []string{config.token}
+	}
+	if len(config.token) > 0 {
+		return append([]string{config.token}, handshakeSecrets(now)...)
+	}
+	return handshakeSecrets(now)
+}
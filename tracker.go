@@ -6,10 +6,11 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
+	"sample-room-golang/gameon/protocol"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -22,8 +23,39 @@ const (
 // chat broadcasts.
 type PlayerConnection struct {
 	playerId string
+	username string
 	roomId   string
-	conn     *websocket.Conn
+	sess     *Session
+	// The protocol version negotiated for this player. The messages
+	// we send are the same in both versions, so this only decides
+	// whether a lost connection parts the player (2) or ends their
+	// visit (1).
+	version int
+	// partedAt and parted are only touched by the tracker goroutine.
+	// A parted (version 2) player has lost their connection but not
+	// left the room; they are not sent broadcasts and are forgotten
+	// if they do not rejoin within the player TTL.
+	partedAt time.Time
+	parted   bool
+}
+
+// Asks the tracker to start tracking a player. Unless the player was
+// already being tracked in the room, announce is broadcast to the
+// room first. The tracker replies on known.
+type trackRequest struct {
+	pc       *PlayerConnection
+	announce string
+	known    chan bool
+}
+
+// Asks the tracker to stop tracking a player or, sent on part, to
+// note that their connection has gone away.
+type untrackRequest struct {
+	key string
+	// If sess is not nil then the player is only removed if they
+	// are still using sess, and their departure is announced to
+	// the rest of the room.
+	sess *Session
 }
 
 type Broadcast struct {
@@ -43,18 +75,30 @@ type Banter struct {
 	sender  string
 }
 
+// Asks the tracker to send every player on their way.
+type evictRequest struct {
+	message string
+	// If exitId is not empty players are moved out through that exit.
+	exitId string
+	done   chan struct{}
+}
+
 type Tracker struct {
 	players   map[string]*PlayerConnection
-	add       chan *PlayerConnection
-	remove    chan string
+	evict     chan *evictRequest
+	add       chan *trackRequest
+	part      chan *untrackRequest
+	remove    chan *untrackRequest
 	broadcast chan *Broadcast
 	smalltalk chan *Banter
 }
 
 var tracker = Tracker{
 	players:   make(map[string]*PlayerConnection),
-	add:       make(chan *PlayerConnection),
-	remove:    make(chan string),
+	evict:     make(chan *evictRequest),
+	add:       make(chan *trackRequest),
+	part:      make(chan *untrackRequest),
+	remove:    make(chan *untrackRequest),
 	broadcast: make(chan *Broadcast),
 	smalltalk: make(chan *Banter),
 }
@@ -68,12 +112,42 @@ func BroadcastMessage(r, m, sender, receiver string) {
 	tracker.broadcast <- &bc
 }
 
-func TrackPlayer(pc *PlayerConnection) {
-	tracker.add <- pc
+// Starts tracking pc, replacing any entry for the same player in the
+// same room. Returns true if the player was already being tracked,
+// which means that they are reconnecting rather than entering; in
+// that case announce is not broadcast, so that the room does not
+// hear about the same player entering twice.
+func TrackPlayer(pc *PlayerConnection, announce string) (known bool) {
+	req := trackRequest{pc: pc, announce: announce, known: make(chan bool, 1)}
+	tracker.add <- &req
+	return <-req.known
+}
+
+// Notes that a version 2 player's connection, sess, has gone away
+// although they have not left the room. Nothing happens if the player
+// has since rejoined on another session.
+func PartPlayer(roomId, playerId string, sess *Session) {
+	tracker.part <- &untrackRequest{key: makePlayerKey(playerId, roomId), sess: sess}
 }
 
 func UntrackPlayer(roomId, playerId string) {
-	tracker.remove <- makePlayerKey(playerId, roomId)
+	tracker.remove <- &untrackRequest{key: makePlayerKey(playerId, roomId)}
+}
+
+// Untracks a player whose connection has gone away without a
+// goodbye and tells the room that they have left. Nothing happens
+// if the player has since reconnected on another session.
+func untrackDeparted(pc *PlayerConnection) {
+	tracker.remove <- &untrackRequest{key: makePlayerKey(pc.playerId, pc.roomId), sess: pc.sess}
+}
+
+// Tells every tracked player m, optionally moves them out through
+// exitId, and stops tracking them. Returns once that has been queued.
+// A %s in m is replaced by the full name of the player's room.
+func EvictAllPlayers(m, exitId string) {
+	req := evictRequest{message: m, exitId: exitId, done: make(chan struct{})}
+	tracker.evict <- &req
+	<-req.done
 }
 
 func MakeSmalltalk(m, sender string) {
@@ -85,19 +159,59 @@ func MakeSmalltalk(m, sender string) {
 // goroutine before any callbacks are enabled.
 func TrackPlayers() {
 	checkpoint("TRACKER", "STARTED")
+	// A nil channel is never ready, so with no TTL we never sweep.
+	var sweep <-chan time.Time
+	if config.playerTTL > 0 {
+		ticker := time.NewTicker(sweepPeriod())
+		defer ticker.Stop()
+		sweep = ticker.C
+	}
 	for {
 		select {
-		case pc := <-tracker.add:
+		case req := <-tracker.add:
+			pc := req.pc
+			k := makePlayerKey(pc.playerId, pc.roomId)
 			logPlayer(pc, "ADDING", config.debug)
-			tracker.players[makePlayerKey(pc.playerId, pc.roomId)] = pc
-		case k := <-tracker.remove:
-			pc := tracker.players[k]
+			_, known := tracker.players[k]
+			if !known && len(req.announce) > 0 {
+				broadcast(&Broadcast{
+					roomId:   pc.roomId,
+					message:  req.announce,
+					sender:   normalizeBroadcastSender(TrackerSender, "*"),
+					receiver: "*"})
+			}
+			tracker.players[k] = pc
+			req.known <- known
+		case req := <-tracker.part:
+			if pc := tracker.players[req.key]; pc != nil && pc.sess != req.sess {
+				checkpoint("TRACKER", fmt.Sprintf("playerKey=%q has rejoined", req.key))
+			} else if pc != nil && config.playerTTL <= 0 {
+				// With no TTL nothing would ever sweep them up.
+				logPlayer(pc, "FORGETTING", config.debug)
+				delete(tracker.players, req.key)
+			} else if pc != nil {
+				logPlayer(pc, "PARTING", config.debug)
+				pc.parted = true
+				pc.partedAt = time.Now()
+			}
+		case req := <-tracker.remove:
+			pc := tracker.players[req.key]
 			if pc == nil {
-				checkpoint("TRACKER", fmt.Sprintf("k=%s not found", k))
+				checkpoint("TRACKER", fmt.Sprintf("playerKey=%q not found", req.key))
+			} else if req.sess != nil && req.sess != pc.sess {
+				checkpoint("TRACKER", fmt.Sprintf("playerKey=%q has reconnected", req.key))
 			} else {
 				logPlayer(pc, "REMOVING", config.debug)
-				delete(tracker.players, k)
+				delete(tracker.players, req.key)
+				if req.sess != nil {
+					announceDeparture(pc)
+				}
 			}
+		case <-sweep:
+			expirePlayers(time.Now())
+		case req := <-tracker.evict:
+			evictPlayers(req)
+			close(req.done)
 		case bc := <-tracker.broadcast:
 			broadcast(bc)
 		case banter := <-tracker.smalltalk:
@@ -106,6 +220,70 @@ func TrackPlayers() {
 	}
 }
 
+// Removes the players that expired reports on. This must only be
+// called from the tracker goroutine.
+func expirePlayers(now time.Time) {
+	ttl := time.Duration(config.playerTTL) * time.Second
+	for k, pc := range tracker.players {
+		if expired(pc, now, ttl) {
+			logPlayer(pc, "EXPIRING", config.debug)
+			delete(tracker.players, k)
+			announceDeparture(pc)
+		}
+	}
+}
+
+// Reports whether pc should be forgotten at now. A player whose
+// session is open stays however quiet they are. A version 1 player
+// whose session has closed is gone, and a version 2 player who parted
+// more than ttl ago is not coming back.
+func expired(pc *PlayerConnection, now time.Time, ttl time.Duration) bool {
+	switch {
+	case pc.parted:
+		return now.Sub(pc.partedAt) > ttl
+	case pc.sess.Closed():
+		return pc.version < 2
+	}
+	return false
+}
+
+// Sends every player on their way. This must only be called from the
+// tracker goroutine.
+func evictPlayers(req *evictRequest) {
+	for k, pc := range tracker.players {
+		logPlayer(pc, "EVICTING", config.debug)
+		CancelTimedText(pc.roomId, pc.playerId)
+		m := fmt.Sprintf(req.message, roomFullName(pc.roomId))
+		SendMessageToPlayer(pc.sess, m, pc.playerId)
+		if len(req.exitId) > 0 {
+			SendMessage(pc.sess, pc.playerId, protocol.NewExit(req.exitId, m))
+		}
+		pc.sess.unbind(pc.roomId, pc.playerId)
+		delete(tracker.players, k)
+	}
+}
+
+// Tells the rest of the room that pc's player has left. This must
+// only be called from the tracker goroutine; it calls broadcast
+// directly because sending to tracker.broadcast would deadlock.
+func announceDeparture(pc *PlayerConnection) {
+	m := fmt.Sprintf("%s has left %s.", pc.username, roomFullName(pc.roomId))
+	broadcast(&Broadcast{
+		roomId:   pc.roomId,
+		message:  m,
+		sender:   normalizeBroadcastSender(TrackerSender, "*"),
+		receiver: "*"})
+}
+
+// How often the tracker looks for expired players.
+func sweepPeriod() time.Duration {
+	ttl := time.Duration(config.playerTTL) * time.Second
+	if ttl < time.Minute {
+		return ttl
+	}
+	return time.Minute
+}
+
 func makePlayerKey(playerId, roomId string) string {
 	return fmt.Sprintf("%s-%s", playerId, roomId)
 }
@@ -128,36 +306,27 @@ func logBroadcast(bc *Broadcast, note string, logging bool) {
 		checkpoint(locus, fmt.Sprintf("note=%s", note))
 	}
 	checkpoint(locus, fmt.Sprintf("roomId=%s", bc.roomId))
-	checkpoint(locus, fmt.Sprintf("sender=%s", bc.sender))
+	checkpoint(locus, fmt.Sprintf("sender=%q", bc.sender))
 	checkpoint(locus, fmt.Sprintf("receiver=%s", bc.receiver))
-	checkpoint(locus, fmt.Sprintf("message=%s", bc.message))
-}
-
-type ChatMessage struct {
-	Rtype    string `json:"type,omitempty"`
-	Username string `json:"username,omitempty"`
-	Content  string `json:"content,omitempty"`
-	Bookmark int    `json:"bookmark,omitempty"`
+	checkpoint(locus, fmt.Sprintf("message=%q", bc.message))
 }
 
 func broadcast(bc *Broadcast) {
 	logBroadcast(bc, "candidate", config.debug)
-	for _, pc := range tracker.players {
+	for k, pc := range tracker.players {
+		if pc.parted {
+			continue
+		}
 		r := pc.roomId
 		if len(r) == 0 || r == bc.roomId {
 			logBroadcast(bc, "sending", config.debug)
-			c := pc.conn
-			var m ChatMessage
-			m.Rtype = "chat"
-			m.Username = bc.sender
-			m.Content = bc.message
-			m.Bookmark = 0
-			j, err := json.MarshalIndent(m, "", "    ")
+			err := SendMessage(pc.sess, bc.receiver, protocol.NewChat(bc.sender, bc.message))
 			if err != nil {
-				log.Printf("BROADCAST JSON ERROR\n")
-				return
+				// One player's broken connection must not keep the
+				// others from hearing it.
+				log.Printf("BROADCAST ERROR playerKey=%q err=%s\n", k, err.Error())
+				continue
 			}
-			SendMessage(c, bc.receiver, j, MTPlayer)
 		} else {
 			if config.debug {
 				log.Printf("BROADCAST.%s REJECT\n", r)
@@ -166,30 +335,28 @@ func broadcast(bc *Broadcast) {
 	}
 }
 
-var bcCounter = 0
+// Broadcasts come from the read loops and from the tracker itself,
+// so only access with sync/atomic.
+var bcCounter int64
 
 // Normalize morphs the special tracker id into a tracker
 // id with a message number appended.
 func normalizeBroadcastSender(s, r string) string {
 	if s == TrackerSender {
-		bcCounter += 1
-		return fmt.Sprintf("tracker.%04d", bcCounter)
+		return fmt.Sprintf("tracker.%04d", atomic.AddInt64(&bcCounter, 1))
 	}
 	return s
 }
 
 func smalltalk(banter *Banter) {
-	for _, pc := range tracker.players {
-		var m ChatMessage
-		m.Rtype = "chat"
-		m.Username = banter.sender
-		m.Content = banter.message
-		m.Bookmark = 0
-		j, err := json.MarshalIndent(m, "", "    ")
+	for k, pc := range tracker.players {
+		if pc.parted {
+			continue
+		}
+		err := SendMessage(pc.sess, "*", protocol.NewChat(banter.sender, banter.message))
 		if err != nil {
-			log.Printf("smalltalk JSON ERROR\n")
-			return
+			log.Printf("smalltalk ERROR playerKey=%q err=%s\n", k, err.Error())
+			continue
 		}
-		SendMessage(pc.conn, "*", j, MTPlayer)
 	}
 }
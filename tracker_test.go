@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Waits until the tracker has dealt with everything sent to it so far.
+func trackerIdle() {
+	TrackPlayer(&PlayerConnection{playerId: "tracker.idle", roomId: "nowhere", sess: queueSession(1)}, "")
+}
+
+func TestJoinPartReconnect(t *testing.T) {
+	startTracker.Do(func() { go TrackPlayers() })
+	const room, uid = "tracker.room", "dummy.Traveller"
+	defer UntrackPlayer(room, uid)
+	watcher := queueSession(16)
+	TrackPlayer(&PlayerConnection{playerId: "dummy.Watcher", roomId: room, sess: watcher, version: 2}, "")
+	defer UntrackPlayer(room, "dummy.Watcher")
+
+	first := queueSession(16)
+	pc := &PlayerConnection{playerId: uid, username: "Traveller", roomId: room, sess: first, version: 2}
+	if TrackPlayer(pc, "Traveller has entered.") {
+		t.Errorf("A new player was taken for a known one.")
+	}
+	first.bind(pc)
+	trackerIdle()
+	if got := queued(watcher); len(got) != 1 || !strings.Contains(got[0], "has entered") {
+		t.Errorf("The room heard %q", got)
+	}
+
+	// The player rejoins on a second connection before the first ends.
+	second := queueSession(16)
+	if !TrackPlayer(&PlayerConnection{playerId: uid, username: "Traveller", roomId: room, sess: second, version: 2},
+		"Traveller has entered.") {
+		t.Errorf("A rejoining player was taken for a new one.")
+	}
+	first.departAll()
+	trackerIdle()
+	if got := queued(watcher); len(got) != 0 {
+		t.Errorf("After the player rejoined the room heard %q", got)
+	}
+
+	// The part for the first connection did not part the player, who
+	// still hears the room on the second.
+	BroadcastMessage(room, "Is anybody there?", "dummy.Watcher", "*")
+	trackerIdle()
+	if got := queued(second); len(got) != 1 || !strings.Contains(got[0], "Is anybody there?") {
+		t.Errorf("The rejoined player heard %q", got)
+	}
+
+	// Once the second connection parts, the player no longer does.
+	PartPlayer(room, uid, second)
+	BroadcastMessage(room, "Hello?", "dummy.Watcher", "*")
+	trackerIdle()
+	if got := queued(second); len(got) != 0 {
+		t.Errorf("A parted player heard %q", got)
+	}
+}
+
+func TestEvictAllPlayers(t *testing.T) {
+	startTracker.Do(func() { go TrackPlayers() })
+	roomRouter.Add(newRoom("evict.room", "EVICT.ROOM", "The Closing Room"))
+	defer roomRouter.Remove("evict.room")
+	var sessions []*Session
+	for _, uid := range []string{"dummy.First", "dummy.Second"} {
+		s := queueSession(16)
+		pc := &PlayerConnection{playerId: uid, username: uid, roomId: "evict.room", sess: s, version: 2}
+		TrackPlayer(pc, "")
+		s.bind(pc)
+		sessions = append(sessions, s)
+	}
+
+	EvictAllPlayers("%s is closing.", "n")
+	for i, s := range sessions {
+		got := queued(s)
+		if len(got) != 2 || !strings.Contains(got[0], "The Closing Room is closing.") ||
+			!strings.HasPrefix(got[1], "playerLocation,") || !strings.Contains(got[1], `"exitId":"n"`) {
+			t.Errorf("Player %d was sent %q", i+1, got)
+		}
+		if len(s.players) != 0 {
+			t.Errorf("Player %d is still bound to their session.", i+1)
+		}
+	}
+	// Evicted players no longer hear the room. With no one left to
+	// evict, EvictAllPlayers returns once the broadcast is done.
+	BroadcastMessage("evict.room", "Is anybody there?", "dummy.Caretaker", "*")
+	EvictAllPlayers("", "")
+	for i, s := range sessions {
+		if got := queued(s); len(got) != 0 {
+			t.Errorf("Evicted player %d heard %q", i+1, got)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	const ttl = time.Hour
+	now := time.Now()
+	closed := queueSession(1)
+	close(closed.done)
+	cases := []struct {
+		what string
+		pc   *PlayerConnection
+		want bool
+	}{
+		{"A quiet player who is still connected", &PlayerConnection{sess: queueSession(1), version: 2}, false},
+		{"A version 1 player whose session closed", &PlayerConnection{sess: closed, version: 1}, true},
+		{"A version 2 player whose session closed", &PlayerConnection{sess: closed, version: 2}, false},
+		{"A player who parted within the TTL",
+			&PlayerConnection{sess: closed, version: 2, parted: true, partedAt: now.Add(-ttl / 2)}, false},
+		{"A player who parted before the TTL",
+			&PlayerConnection{sess: closed, version: 2, parted: true, partedAt: now.Add(-2 * ttl)}, true},
+	}
+	for _, c := range cases {
+		if got := expired(c.pc, now, ttl); got != c.want {
+			t.Errorf("%s: expired is %v", c.what, got)
+		}
+	}
+}
+
+func TestPartWithoutTTL(t *testing.T) {
+	startTracker.Do(func() { go TrackPlayers() })
+	const room, uid = "ttl.room", "dummy.Parter"
+	s := queueSession(1)
+	TrackPlayer(&PlayerConnection{playerId: uid, roomId: room, sess: s, version: 2}, "")
+	PartPlayer(room, uid, s)
+	// With no TTL a parted player is forgotten at once.
+	if TrackPlayer(&PlayerConnection{playerId: uid, roomId: room, sess: queueSession(1), version: 2}, "") {
+		t.Errorf("A parted player was remembered with no TTL.")
+	}
+	UntrackPlayer(room, uid)
+}
+
+func TestTrackerSendersAreUnique(t *testing.T) {
+	const n = 50
+	ids := make(chan string, 2*n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ids <- normalizeBroadcastSender(TrackerSender, "*")
+		}()
+		go func() {
+			defer wg.Done()
+			SendMessageToPlayer(queueSession(1), "m", "dummy.Reader")
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[string]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("Sender %s was used twice.", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// TLS trust for calls to the map service
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Everything we send to the map service is signed with our id and
+// secret, so we check who we are talking to. By default the map
+// service's certificate must chain to one of the system's CAs or to
+// one in -caFile. -clientCert and -clientKey present a certificate of
+// our own, for endpoints that insist on mutual TLS. -pin lists the
+// SHA-256 hashes of the public keys (SubjectPublicKeyInfo) that we
+// accept in the map service's certificate chain, in the form that
+//
+//   openssl x509 -pubkey -noout | openssl pkey -pubin -outform der |
+//     openssl dgst -sha256 -binary | base64
+//
+// prints, optionally preceded by "sha256/". -insecure turns all
+// checking off; it exists for local servers with made-up certificates
+// and is loudly logged.
+
+// The prefix a pin may carry.
+const pinPrefix = "sha256/"
+
+// Returns the TLS configuration for calls to the map service, as
+// -caFile, -clientCert, -clientKey, -pin and -insecure direct.
+func mapServiceTLS() (*tls.Config, error) {
+	tc := &tls.Config{InsecureSkipVerify: config.insecure}
+	if len(config.caFile) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(config.caFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ArgError{fmt.Sprintf("%s holds no PEM certificates.", config.caFile)}
+		}
+		tc.RootCAs = pool
+	}
+	if (len(config.clientCert) > 0) != (len(config.clientKey) > 0) {
+		return nil, ArgError{"Give both -clientCert and -clientKey, or neither."}
+	}
+	if len(config.clientCert) > 0 {
+		cert, err := tls.LoadX509KeyPair(config.clientCert, config.clientKey)
+		if err != nil {
+			return nil, ArgError{fmt.Sprintf("Cannot load our client certificate: %s", err.Error())}
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	pins, err := parsePins(config.pins)
+	if err != nil {
+		return nil, err
+	}
+	if len(pins) > 0 {
+		// This runs after the usual checks, and even with -insecure.
+		tc.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			var certs []*x509.Certificate
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs = append(certs, cert)
+			}
+			return checkPins(pins, certs)
+		}
+	}
+	return tc, nil
+}
+
+// Parses a comma-separated list of pins into a set of raw hashes.
+func parsePins(list string) (map[string]bool, error) {
+	pins := make(map[string]bool)
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimPrefix(strings.TrimSpace(p), pinPrefix)
+		if len(p) == 0 {
+			continue
+		}
+		h, err := base64.StdEncoding.DecodeString(p)
+		if err != nil || len(h) != sha256.Size {
+			return nil, ArgError{fmt.Sprintf("'%s' is not a base64 SHA-256 pin.", p)}
+		}
+		pins[string(h)] = true
+	}
+	return pins, nil
+}
+
+// Returns the pin of cert's public key.
+func pinOf(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Returns nil if the public key of one of certs is pinned.
+func checkPins(pins map[string]bool, certs []*x509.Certificate) error {
+	var seen []string
+	for _, cert := range certs {
+		h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		if pins[string(h[:])] {
+			return nil
+		}
+		seen = append(seen, pinOf(cert))
+	}
+	return fmt.Errorf("the map service's certificate chain matches none of our pins; it has %s",
+		strings.Join(seen, ", "))
+}
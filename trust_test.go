@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMapServiceTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "trust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { config.caFile, config.pins, config.insecure = "", "", false }()
+
+	get := func() error {
+		tc, err := mapServiceTLS()
+		if err != nil {
+			t.Fatalf("mapServiceTLS failed: %v", err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tc}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(); err == nil {
+		t.Errorf("An unknown CA was trusted.")
+	}
+	config.caFile = caFile
+	if err := get(); err != nil {
+		t.Errorf("The CA in -caFile was not trusted: %v", err)
+	}
+	config.pins = pinOf(srv.Certificate())
+	if err := get(); err != nil {
+		t.Errorf("The pinned key was refused: %v", err)
+	}
+	config.pins = "sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	if err := get(); err == nil {
+		t.Errorf("A certificate that matches no pin was accepted.")
+	}
+	config.caFile, config.pins, config.insecure = "", "", true
+	if err := get(); err != nil {
+		t.Errorf("-insecure did not skip verification: %v", err)
+	}
+	config.insecure = false
+	config.pins = "not-a-pin"
+	if _, err := mapServiceTLS(); err == nil {
+		t.Errorf("A malformed pin was accepted.")
+	}
+}
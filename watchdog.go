@@ -0,0 +1,239 @@
+// Copyright (c) 2016 IBM Corp. All rights reserved.
+// Use of this source code is governed by the Apache License,
+// Version 2.0, a copy of which can be found in the LICENSE file.
+
+// Registration watchdog
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sample-room-golang/gameon/mapclient"
+	"sort"
+	"sync"
+	"time"
+)
+
+// We register at startup, but a registration does not stay put: its
+// owner can delete it in the Game On! UI, or change its callback, and
+// the room would then run on with no one able to reach it. So every
+// -watchInterval seconds the watchdog goes through our rooms again as
+// register does, re-registering any that have gone and updating any
+// that have drifted from our settings (unless -noupdate), and then
+// refreshes MyRooms and roomRouter from the map service.
+//
+// What it found is served as JSON from /status/registration, with a
+// 503 status if any room could not be checked or repaired, and counted
+// in the room_registration_* metrics.
+
+// The state reported for a room that register failed on, in addition
+// to the Reg constants that say what register did.
+const RegFailed = "failed"
+
+var (
+	// Closed to stop the watchdog.
+	watchdogStop = make(chan struct{})
+	// Closed by the watchdog when it has stopped.
+	watchdogDone = make(chan struct{})
+)
+
+// Checks our registrations every config.watchInterval seconds until
+// watchdogStop is closed.
+func WatchRegistrations(mc *mapclient.Client) {
+	locus := "WATCHDOG"
+	defer close(watchdogDone)
+	if config.watchInterval == 0 {
+		checkpoint(locus, "DISABLED")
+		return
+	}
+	// Abandon a check that is under way when we are stopped, so that
+	// it cannot re-register a room that shutdown is deregistering.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-watchdogStop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	checkpoint(locus, "BEGIN")
+	interval := time.Duration(config.watchInterval) * time.Second
+	for pause(interval, watchdogStop) {
+		checkRegistrations(ctx, mc)
+	}
+	checkpoint(locus, "STOPPED")
+}
+
+// Stops the watchdog, waiting until deadline at most for it to finish
+// what it is doing.
+func stopWatchdog(deadline time.Time) {
+	close(watchdogStop)
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case <-watchdogDone:
+	case <-t.C:
+	}
+}
+
+// Checks, and if need be repairs, the registration of each of our
+// rooms, then refreshes MyRooms.
+func checkRegistrations(ctx context.Context, mc *mapclient.Client) {
+	locus := "WATCHDOG.CHECK"
+	for _, spec := range roomSpecs {
+		if ctx.Err() != nil {
+			return
+		}
+		action, err := register(ctx, mc, spec)
+		registrationState.record(spec, action, err)
+		if err != nil {
+			checkpoint(locus, fmt.Sprintf("FAILED %s err=%s", spec.Name, err.Error()))
+			continue
+		}
+		if action != RegUnchanged {
+			checkpoint(locus, fmt.Sprintf("REPAIRED %s %s", spec.Name, action))
+			registrationRepairs.WithLabelValues(action).Inc()
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	err := rememberMyRooms(ctx, mc)
+	registrationState.checked(err)
+}
+
+// The state of one of our rooms as last seen by register.
+type siteState struct {
+	Name  string `json:"name"`
+	Id    string `json:"id,omitempty"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+	// When State last changed.
+	Since time.Time `json:"since"`
+}
+
+// What /status/registration serves.
+type registrationReport struct {
+	Healthy   bool        `json:"healthy"`
+	LastCheck time.Time   `json:"lastCheck"`
+	Error     string      `json:"error,omitempty"`
+	Sites     []siteState `json:"sites"`
+	// The full name of every site we own, by id, and the ids of
+	// those that we serve.
+	MyRooms map[string]string `json:"myRooms"`
+	Serving []string          `json:"serving"`
+}
+
+// Records what register and the watchdog found.
+type registrationStatus struct {
+	mu        sync.Mutex
+	lastCheck time.Time
+	lastError string
+	sites     map[string]*siteState
+}
+
+var registrationState = registrationStatus{sites: make(map[string]*siteState)}
+
+// Records what register did about spec.
+func (rs *registrationStatus) record(spec *roomSpec, action string, err error) {
+	s := siteState{Name: spec.Name, State: action}
+	if site := rememberedRegistration(spec.Name); site != nil {
+		s.Id = site.Id
+	}
+	if err != nil {
+		if action != RegDegraded {
+			s.State = RegFailed
+		}
+		s.Error = err.Error()
+	}
+	now := time.Now()
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	s.Since = now
+	if old := rs.sites[spec.Name]; old != nil && old.State == s.State {
+		s.Since = old.Since
+	}
+	rs.sites[spec.Name] = &s
+	rs.updateMetrics()
+}
+
+// Records the end of a check, which failed if err is not nil.
+func (rs *registrationStatus) checked(err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.lastCheck = time.Now()
+	rs.lastError = ""
+	result := "ok"
+	if err != nil {
+		rs.lastError = err.Error()
+		result = RegFailed
+	}
+	registrationChecks.WithLabelValues(result).Inc()
+	registrationLastCheck.Set(float64(rs.lastCheck.Unix()))
+	rs.updateMetrics()
+}
+
+// Reports whether every room is registered and the last refresh of
+// MyRooms worked. Only call with rs.mu held.
+func (rs *registrationStatus) healthy() bool {
+	if len(rs.lastError) > 0 {
+		return false
+	}
+	for _, s := range rs.sites {
+		if s.State == RegFailed || s.State == RegDegraded {
+			return false
+		}
+	}
+	return true
+}
+
+// Only call with rs.mu held.
+func (rs *registrationStatus) updateMetrics() {
+	if rs.healthy() {
+		registrationHealthy.Set(1)
+	} else {
+		registrationHealthy.Set(0)
+	}
+}
+
+// Returns a copy of the current state.
+func (rs *registrationStatus) report() registrationReport {
+	rs.mu.Lock()
+	r := registrationReport{
+		Healthy:   rs.healthy(),
+		LastCheck: rs.lastCheck,
+		Error:     rs.lastError,
+		Sites:     make([]siteState, 0, len(rs.sites)),
+	}
+	for _, s := range rs.sites {
+		r.Sites = append(r.Sites, *s)
+	}
+	rs.mu.Unlock()
+	sort.Slice(r.Sites, func(i, j int) bool { return r.Sites[i].Name < r.Sites[j].Name })
+
+	r.MyRooms = make(map[string]string)
+	myRoomsMu.RLock()
+	for id, name := range MyRooms {
+		r.MyRooms[id] = name
+	}
+	myRoomsMu.RUnlock()
+	r.Serving = []string{}
+	for _, room := range roomRouter.Rooms() {
+		r.Serving = append(r.Serving, room.id)
+	}
+	sort.Strings(r.Serving)
+	return r
+}
+
+// Serves the watchdog's findings.
+func RegistrationStatusGET(c *gin.Context) {
+	r := registrationState.report()
+	code := http.StatusOK
+	if !r.Healthy {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, r)
+}